@@ -1,18 +1,20 @@
 module github.com/zathras777/zcan
 
-go 1.19
+go 1.21
 
-require go.einride.tech/can v0.5.5
+require (
+	github.com/mdlayher/netlink v1.7.1
+	go.einride.tech/can v0.5.5
+	golang.org/x/sync v0.1.0
+	golang.org/x/sys v0.6.0
+)
 
 require (
 	github.com/golang/mock v1.6.0 // indirect
 	github.com/google/go-cmp v0.5.9 // indirect
 	github.com/josharian/native v1.0.0 // indirect
-	github.com/mdlayher/netlink v1.7.1 // indirect
 	github.com/mdlayher/socket v0.4.0 // indirect
 	golang.org/x/mod v0.9.0 // indirect
 	golang.org/x/net v0.8.0 // indirect
-	golang.org/x/sync v0.1.0 // indirect
-	golang.org/x/sys v0.6.0 // indirect
 	golang.org/x/tools v0.7.0 // indirect
 )
@@ -0,0 +1,223 @@
+package zcan
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.einride.tech/can"
+)
+
+const (
+	cannelloniVersion    = 2
+	cannelloniOpData     = 0
+	cannelloniHeaderSize = 5
+	cannelloniMaxPacket  = 1500
+)
+
+// cannelloniConnection is a Transport that exchanges frames with a remote
+// cannelloni instance using its UDP protocol.
+type cannelloniConnection struct {
+	remote  string
+	local   string
+	dropped atomic.Uint64
+
+	mu      sync.Mutex
+	conn    *net.UDPConn
+	peer    *net.UDPAddr
+	seq     byte
+	pending []TimedFrame
+}
+
+// NewCannelloniTransport returns a Transport that sends frames to the
+// cannelloni instance at remote and receives frames on the local address.
+// If local is empty the port of the remote address is used.
+func NewCannelloniTransport(remote, local string) (Transport, error) {
+	if local == "" {
+		_, port, err := net.SplitHostPort(remote)
+		if err != nil {
+			return nil, err
+		}
+		local = ":" + port
+	}
+	return &cannelloniConnection{remote: remote, local: local}, nil
+}
+
+func (conn *cannelloniConnection) Open() error {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if conn.conn != nil {
+		return nil
+	}
+	peer, err := net.ResolveUDPAddr("udp", conn.remote)
+	if err != nil {
+		return err
+	}
+	laddr, err := net.ResolveUDPAddr("udp", conn.local)
+	if err != nil {
+		return err
+	}
+	c, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		return err
+	}
+	conn.conn = c
+	conn.peer = peer
+	conn.pending = nil
+	return nil
+}
+
+func (conn *cannelloniConnection) Receive() (TimedFrame, error) {
+	buf := make([]byte, cannelloniMaxPacket)
+	for {
+		conn.mu.Lock()
+		c := conn.conn
+		if len(conn.pending) > 0 {
+			frame := conn.pending[0]
+			conn.pending = conn.pending[1:]
+			conn.mu.Unlock()
+			return frame, nil
+		}
+		conn.mu.Unlock()
+		if c == nil {
+			return TimedFrame{}, io.EOF
+		}
+
+		n, addr, err := c.ReadFromUDP(buf)
+		if err != nil {
+			return TimedFrame{}, err
+		}
+		if !conn.fromPeer(addr) {
+			conn.dropped.Add(1)
+			continue
+		}
+		now := time.Now()
+		frames, err := cannelloniDecode(buf[:n])
+		if err != nil {
+			continue
+		}
+		conn.mu.Lock()
+		for _, frame := range frames {
+			conn.pending = append(conn.pending, TimedFrame{Frame: frame, Timestamp: now})
+		}
+		conn.mu.Unlock()
+	}
+}
+
+// fromPeer returns true if addr is the address of the remote instance.
+func (conn *cannelloniConnection) fromPeer(addr *net.UDPAddr) bool {
+	conn.mu.Lock()
+	peer := conn.peer
+	conn.mu.Unlock()
+	return peer != nil && addr.IP.Equal(peer.IP) && addr.Port == peer.Port
+}
+
+// Dropped returns the number of packets ignored because they didn't come
+// from the remote instance.
+func (conn *cannelloniConnection) Dropped() uint64 {
+	return conn.dropped.Load()
+}
+
+func (conn *cannelloniConnection) Transmit(ctx context.Context, frame can.Frame) error {
+	conn.mu.Lock()
+	c, peer := conn.conn, conn.peer
+	seq := conn.seq
+	conn.seq++
+	conn.mu.Unlock()
+	if c == nil {
+		return fmt.Errorf("cannelloni connection to %s is not open", conn.remote)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		c.SetWriteDeadline(deadline)
+		defer c.SetWriteDeadline(time.Time{})
+	}
+	_, err := c.WriteToUDP(cannelloniEncode(seq, frame), peer)
+	return err
+}
+
+func (conn *cannelloniConnection) Close() error {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if conn.conn == nil {
+		return nil
+	}
+	err := conn.conn.Close()
+	conn.conn = nil
+	return err
+}
+
+func cannelloniEncode(seq byte, frame can.Frame) []byte {
+	pkt := []byte{cannelloniVersion, cannelloniOpData, seq, 0, 1}
+	id := frame.ID
+	if frame.IsExtended {
+		id |= canEFFFlag
+	}
+	if frame.IsRemote {
+		id |= canRTRFlag
+	}
+	pkt = binary.BigEndian.AppendUint32(pkt, id)
+	pkt = append(pkt, frame.Length)
+	if !frame.IsRemote {
+		pkt = append(pkt, frame.Data[:frame.Length]...)
+	}
+	return pkt
+}
+
+func cannelloniDecode(pkt []byte) ([]can.Frame, error) {
+	if len(pkt) < cannelloniHeaderSize {
+		return nil, fmt.Errorf("short cannelloni packet")
+	}
+	if pkt[0] != cannelloniVersion || pkt[1] != cannelloniOpData {
+		return nil, fmt.Errorf("unsupported cannelloni packet version %d op %d", pkt[0], pkt[1])
+	}
+	count := int(binary.BigEndian.Uint16(pkt[3:5]))
+	pkt = pkt[cannelloniHeaderSize:]
+
+	frames := make([]can.Frame, 0, count)
+	for n := 0; n < count; n++ {
+		if len(pkt) < 5 {
+			return frames, fmt.Errorf("truncated cannelloni packet")
+		}
+		id := binary.BigEndian.Uint32(pkt)
+		length := pkt[4]
+		pkt = pkt[5:]
+		if length&0x80 == 0x80 {
+			// CAN FD frames carry an extra flags byte and are of no use to us.
+			length &= 0x7F
+			if len(pkt) < 1+int(length) {
+				return frames, fmt.Errorf("truncated cannelloni packet")
+			}
+			pkt = pkt[1+int(length):]
+			continue
+		}
+		frame := can.Frame{
+			IsExtended: id&canEFFFlag != 0,
+			IsRemote:   id&canRTRFlag != 0,
+			Length:     length,
+		}
+		if frame.IsExtended {
+			frame.ID = id & canEFFMask
+		} else {
+			frame.ID = id & canSFFMask
+		}
+		if length > 8 {
+			return frames, fmt.Errorf("invalid cannelloni frame length %d", length)
+		}
+		if !frame.IsRemote {
+			if len(pkt) < int(length) {
+				return frames, fmt.Errorf("truncated cannelloni packet")
+			}
+			copy(frame.Data[:], pkt[:length])
+			pkt = pkt[length:]
+		}
+		if id&canERRFlag == 0 {
+			frames = append(frames, frame)
+		}
+	}
+	return frames, nil
+}
@@ -0,0 +1,237 @@
+package zcan
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"sync"
+)
+
+// catalogVersion is the version of the catalog file format.
+const catalogVersion = 1
+
+// maxDecimalPlaces limits the precision of scaled sensor values.
+const maxDecimalPlaces = 6
+
+// defaultCatalog describes the ComfoAir Q sensors whose meaning is known,
+// which is only some of the published PDOs.
+//
+//go:embed catalog.json
+var defaultCatalog []byte
+
+// sensorData holds the default sensors used to populate each device's
+// SensorCatalog. It must not be changed.
+var sensorData = mustParseCatalog(defaultCatalog)
+
+// catalogFile is the layout of a catalog file. Entries without a slug are
+// given one based on the name, those without units have unknown units and
+// those without a scale are unscaled. A scale must be greater than 0, and
+// each PDO and slug may only appear once.
+type catalogFile struct {
+	Version int `json:"version"`
+	Sensors []struct {
+		PDO         int              `json:"pdo"`
+		Name        string           `json:"name"`
+		Slug        string           `json:"slug"`
+		Units       string           `json:"units"`
+		Type        ZehnderType      `json:"type"`
+		Scale       *float64         `json:"scale"`
+		Offset      float64          `json:"offset"`
+		Enum        map[int64]string `json:"enum"`
+		Description string           `json:"description"`
+	} `json:"sensors"`
+}
+
+func parseCatalog(r io.Reader) (map[int]PDOSensor, error) {
+	var file catalogFile
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&file); err != nil {
+		return nil, fmt.Errorf("invalid catalog: %w", err)
+	}
+	if file.Version != catalogVersion {
+		return nil, fmt.Errorf("unsupported catalog version %d, expected %d", file.Version, catalogVersion)
+	}
+	sensors := make(map[int]PDOSensor, len(file.Sensors))
+	slugs := make(map[string]int, len(file.Sensors))
+	for _, entry := range file.Sensors {
+		if entry.Name == "" {
+			return nil, fmt.Errorf("catalog entry for PDO %d has no name", entry.PDO)
+		}
+		if _, ck := sensors[entry.PDO]; ck {
+			return nil, fmt.Errorf("catalog has more than one entry for PDO %d", entry.PDO)
+		}
+		sensor := PDOSensor{
+			Name:        entry.Name,
+			slug:        strings.ToLower(entry.Slug),
+			Units:       entry.Units,
+			DataType:    entry.Type,
+			Scale:       1,
+			Offset:      entry.Offset,
+			Enum:        entry.Enum,
+			Description: entry.Description,
+		}
+		if sensor.slug == "" {
+			sensor.slug = slugify(sensor.Name)
+		}
+		if sensor.Units == "" {
+			sensor.Units = UNIT_UNKNOWN
+		}
+		if entry.Scale != nil {
+			if *entry.Scale <= 0 {
+				return nil, fmt.Errorf("catalog entry for PDO %d has invalid scale %g", entry.PDO, *entry.Scale)
+			}
+			sensor.Scale = *entry.Scale
+		}
+		sensor.DecimalPlaces = decimalPlaces(sensor.Scale, sensor.Offset)
+		if pdo, ck := slugs[sensor.slug]; ck {
+			return nil, fmt.Errorf("catalog entries for PDOs %d and %d have the same slug %q", pdo, entry.PDO, sensor.slug)
+		}
+		slugs[sensor.slug] = entry.PDO
+		sensors[entry.PDO] = sensor
+	}
+	return sensors, nil
+}
+
+// decimalPlaces returns the number of decimal places needed to show a raw
+// value multiplied by scale and then offset, e.g. 2 for a scale of 0.25 or
+// 1 for a scale of 1 and an offset of 0.5.
+func decimalPlaces(scale, offset float64) int {
+	places := 0
+	for _, v := range []float64{scale, offset} {
+		n := 0
+		for ; n < maxDecimalPlaces; v *= 10 {
+			if math.Abs(v-math.Round(v)) < 1e-9 {
+				break
+			}
+			n++
+		}
+		places = max(places, n)
+	}
+	return places
+}
+
+func mustParseCatalog(data []byte) map[int]PDOSensor {
+	sensors, err := parseCatalog(bytes.NewReader(data))
+	if err != nil {
+		panic(err)
+	}
+	return sensors
+}
+
+// SensorCatalog describes the PDO sensors known to a device. Each device
+// has its own catalog, starting with the sensors in sensorData, to which
+// sensors seen on the bus but not otherwise known are added.
+type SensorCatalog struct {
+	mu      sync.RWMutex
+	sensors map[int]PDOSensor
+}
+
+// NewSensorCatalog returns a catalog containing the default sensors.
+func NewSensorCatalog() *SensorCatalog {
+	cat := &SensorCatalog{sensors: make(map[int]PDOSensor, len(sensorData))}
+	for pdo, sensor := range sensorData {
+		cat.sensors[pdo] = sensor
+	}
+	return cat
+}
+
+// LoadSensorCatalog returns a catalog containing the default sensors,
+// extended and overridden by those in the catalog file given.
+func LoadSensorCatalog(filename string) (*SensorCatalog, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	cat := NewSensorCatalog()
+	if err := cat.Load(f); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return cat, nil
+}
+
+// Load adds the sensors in the catalog read from r, replacing any with the
+// same PDO. The catalog is rejected if it uses the slug of a sensor it
+// doesn't replace.
+func (cat *SensorCatalog) Load(r io.Reader) error {
+	sensors, err := parseCatalog(r)
+	if err != nil {
+		return err
+	}
+	slugs := make(map[string]int, len(sensors))
+	for pdo, sensor := range sensors {
+		slugs[sensor.slug] = pdo
+	}
+	cat.mu.Lock()
+	defer cat.mu.Unlock()
+	for pdo, sensor := range cat.sensors {
+		if _, replaced := sensors[pdo]; replaced {
+			continue
+		}
+		if other, ck := slugs[sensor.slug]; ck {
+			return fmt.Errorf("slug %q of PDO %d is already used by PDO %d", sensor.slug, other, pdo)
+		}
+	}
+	for pdo, sensor := range sensors {
+		cat.sensors[pdo] = sensor
+	}
+	return nil
+}
+
+// Sensor returns the sensor for a PDO.
+func (cat *SensorCatalog) Sensor(pdo int) (PDOSensor, bool) {
+	cat.mu.RLock()
+	defer cat.mu.RUnlock()
+	sensor, ck := cat.sensors[pdo]
+	return sensor, ck
+}
+
+// SensorBySlug returns the PDO and sensor with the slug supplied.
+func (cat *SensorCatalog) SensorBySlug(slug string) (int, PDOSensor, bool) {
+	slug = strings.ToLower(slug)
+	cat.mu.RLock()
+	defer cat.mu.RUnlock()
+	for pdo, sensor := range cat.sensors {
+		if sensor.slug == slug {
+			return pdo, sensor, true
+		}
+	}
+	return 0, PDOSensor{}, false
+}
+
+// Sensors returns a copy of the catalog's contents.
+func (cat *SensorCatalog) Sensors() map[int]PDOSensor {
+	cat.mu.RLock()
+	defer cat.mu.RUnlock()
+	rv := make(map[int]PDOSensor, len(cat.sensors))
+	for pdo, sensor := range cat.sensors {
+		rv[pdo] = sensor
+	}
+	return rv
+}
+
+// findSensor returns the sensor for a PDO, adding a placeholder based on
+// the length of the data for PDOs that aren't in the catalog. The boolean
+// is true if the placeholder was added.
+func (cat *SensorCatalog) findSensor(pdo int, dataLen int) (PDOSensor, bool) {
+	cat.mu.Lock()
+	defer cat.mu.Unlock()
+	sensor, ck := cat.sensors[pdo]
+	if !ck {
+		sensorName := fmt.Sprintf("Unknown sensor %d", pdo)
+		sensor = PDOSensor{Name: sensorName, slug: slugify(sensorName), Units: UNIT_UNKNOWN, DataType: CN_UINT16, Scale: 1}
+		if dataLen == 1 {
+			sensor.DataType = CN_UINT8
+		} else if dataLen == 4 {
+			sensor.DataType = CN_UINT32
+		}
+		cat.sensors[pdo] = sensor
+	}
+	return sensor, !ck
+}
@@ -0,0 +1,70 @@
+package zcan
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCatalogScale(t *testing.T) {
+	for _, tc := range []struct {
+		scale    string
+		places   int
+		rejected bool
+	}{
+		{"", 0, false},
+		{`, "scale": 1`, 0, false},
+		{`, "scale": 5`, 0, false},
+		{`, "scale": 0.1`, 1, false},
+		{`, "scale": 0.01`, 2, false},
+		{`, "scale": 0.25`, 2, false},
+		{`, "scale": 0.125`, 3, false},
+		{`, "scale": 0.333333333333`, maxDecimalPlaces, false},
+		{`, "offset": 0.5`, 1, false},
+		{`, "offset": -40`, 0, false},
+		{`, "scale": 0.1, "offset": 0.25`, 2, false},
+		{`, "scale": 0.01, "offset": -0.5`, 2, false},
+		{`, "scale": 0`, 0, true},
+		{`, "scale": -0.1`, 0, true},
+	} {
+		catalog := `{"version": 1, "sensors": [{"pdo": 1, "name": "Test", "type": "int16"` + tc.scale + `}]}`
+		sensors, err := parseCatalog(strings.NewReader(catalog))
+		if tc.rejected {
+			if err == nil {
+				t.Errorf("scale%q: catalog accepted", tc.scale)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("scale%q: %v", tc.scale, err)
+			continue
+		}
+		if got := sensors[1].DecimalPlaces; got != tc.places {
+			t.Errorf("scale%q: %d decimal places, expected %d", tc.scale, got, tc.places)
+		}
+	}
+}
+
+func TestCatalogLoad(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		sensors  string
+		slugPDO  int
+		rejected bool
+	}{
+		{"new sensor", `{"pdo": 1, "name": "Test"}`, 120, false},
+		{"replaced sensor", `{"pdo": 120, "name": "Supply Fan Flow", "slug": "supply_fan_flow"}`, 120, false},
+		{"slug moved", `{"pdo": 120, "name": "Fan Flow"}, {"pdo": 1, "name": "Supply Fan Flow", "slug": "supply_fan_flow"}`, 1, false},
+		{"slug in use", `{"pdo": 1, "name": "Test", "slug": "supply_fan_flow"}`, 120, true},
+		{"duplicate slug", `{"pdo": 1, "name": "Test"}, {"pdo": 2, "name": "test"}`, 120, true},
+		{"duplicate PDO", `{"pdo": 1, "name": "Test"}, {"pdo": 1, "name": "Other"}`, 120, true},
+	} {
+		cat := NewSensorCatalog()
+		err := cat.Load(strings.NewReader(`{"version": 1, "sensors": [` + tc.sensors + `]}`))
+		if tc.rejected != (err != nil) {
+			t.Errorf("%s: Load returned %v", tc.name, err)
+		}
+		if pdo, _, ck := cat.SensorBySlug("supply_fan_flow"); !ck || pdo != tc.slugPDO {
+			t.Errorf("%s: supply_fan_flow is PDO %d, expected %d", tc.name, pdo, tc.slugPDO)
+		}
+	}
+}
@@ -3,85 +3,258 @@ package zcan
 import (
 	"context"
 	"fmt"
+	"io"
 	"net"
+	"os"
+	"sync"
 
+	"go.einride.tech/can"
 	"go.einride.tech/can/pkg/candevice"
 	"go.einride.tech/can/pkg/socketcan"
 )
 
+// DefaultBitrate is the bitrate used by ComfoAir Q units.
+const DefaultBitrate = 50000
+
+// SocketCANConfig controls how a socketcan interface is used.
+type SocketCANConfig struct {
+	Interface string
+	// ManageLink sets the bitrate and restart-ms of the interface and brings
+	// the link up when opened, which requires CAP_NET_ADMIN. If the link was
+	// brought up it is taken down again when closed. When false the link is
+	// expected to have been configured already, e.g. by systemd-networkd,
+	// and only a raw CAN socket is opened.
+	ManageLink bool
+	Bitrate    uint32
+	// RestartMs is the bus-off restart delay set when managing the link,
+	// with 0 disabling the automatic restart. If nil the interface's
+	// current setting is left alone.
+	RestartMs *uint32
+}
+
+// zConnection is the socketcan implementation of Transport.
 type zConnection struct {
-	interfaceName string
-	device        *candevice.Device
-	counter       int
-	conn          net.Conn
+	cfg       SocketCANConfig
+	device    *candevice.Device
+	broughtUp bool
+
+	mu          sync.Mutex
+	errorFrames uint64
+	busOffs     uint64
+	filters     []CANFilter
+	file        *os.File
+	reader      *canReader
+	recv        *socketcan.Receiver
+	tx          *socketcan.Transmitter
 }
 
-func (conn *zConnection) open_device(interfaceName string) error {
-	conn.interfaceName = interfaceName
-	d, err := candevice.New(conn.interfaceName)
+// canFileConn provides the net.Conn needed by socketcan.Transmitter for a
+// raw CAN socket.
+type canFileConn struct {
+	*os.File
+}
+
+func (c canFileConn) LocalAddr() net.Addr  { return nil }
+func (c canFileConn) RemoteAddr() net.Addr { return nil }
+
+// NewSocketCANTransport returns a Transport using the named socketcan
+// network interface, e.g. can0. The interface must already be configured
+// and up.
+func NewSocketCANTransport(interfaceName string) (Transport, error) {
+	return NewSocketCANTransportWithConfig(SocketCANConfig{Interface: interfaceName})
+}
+
+// NewSocketCANTransportWithConfig returns a Transport using a socketcan
+// network interface configured as described by cfg.
+func NewSocketCANTransportWithConfig(cfg SocketCANConfig) (Transport, error) {
+	if _, err := net.InterfaceByName(cfg.Interface); err != nil {
+		return nil, fmt.Errorf("interface %s: %w", cfg.Interface, err)
+	}
+	if cfg.Bitrate == 0 {
+		cfg.Bitrate = DefaultBitrate
+	}
+	conn := &zConnection{cfg: cfg}
+	if cfg.ManageLink {
+		d, err := candevice.New(cfg.Interface)
+		if err != nil {
+			return nil, err
+		}
+		conn.device = d
+	}
+	return conn, nil
+}
+
+// configureLink applies the bitrate and restart-ms settings, which can only
+// be changed while the link is down, and then brings the link up.
+func (conn *zConnection) configureLink() error {
+	d := conn.device
+	up, err := d.IsUp()
 	if err != nil {
 		return err
 	}
-	var br uint32
-	br, err = d.Bitrate()
+	bitrate, err := d.Bitrate()
 	if err != nil {
 		return err
 	}
-	if br != 50000 {
-		err = d.SetBitrate(50000)
+	setBitrate := bitrate != conn.cfg.Bitrate
+	setRestart := false
+	if conn.cfg.RestartMs != nil {
+		restartMs, err := linkRestartMs(d)
 		if err != nil {
 			return err
 		}
+		setRestart = restartMs != *conn.cfg.RestartMs
 	}
-	var ck bool
-	ck, err = d.IsUp()
-	if err != nil {
-		return err
+	if setBitrate || setRestart {
+		if up {
+			if err := d.SetDown(); err != nil {
+				return err
+			}
+			up = false
+		}
+		if setBitrate {
+			if err := d.SetBitrate(conn.cfg.Bitrate); err != nil {
+				return err
+			}
+		}
+		if setRestart {
+			if err := setLinkRestartMs(conn.cfg.Interface, *conn.cfg.RestartMs); err != nil {
+				return err
+			}
+		}
 	}
-	if !ck {
-		err = d.SetUp()
-		if err != nil {
+	if !up {
+		if err := d.SetUp(); err != nil {
 			return err
 		}
+		conn.broughtUp = true
 	}
-	conn.device = d
-	defer d.SetDown()
 	return nil
 }
 
-func (conn *zConnection) open() error {
-	if conn.device == nil {
-		return fmt.Errorf("require an interface name. Have you called Connect()")
+func (conn *zConnection) Open() error {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if conn.file != nil {
+		return nil
 	}
-	if conn.counter == 0 {
-		var err error
-		conn.conn, err = socketcan.DialContext(context.Background(), "can", conn.interfaceName)
+	if conn.cfg.ManageLink {
+		if err := conn.configureLink(); err != nil {
+			return fmt.Errorf("unable to configure %s: %w", conn.cfg.Interface, err)
+		}
+	} else {
+		ifi, err := net.InterfaceByName(conn.cfg.Interface)
 		if err != nil {
-			fmt.Println(err)
 			return err
 		}
-		conn.counter = 1
-	} else {
-		conn.counter += 1
+		if ifi.Flags&net.FlagUp == 0 {
+			return fmt.Errorf("interface %s is down", conn.cfg.Interface)
+		}
+	}
+	f, err := dialCANRaw(conn.cfg.Interface)
+	if err != nil {
+		return err
+	}
+	if err := setCANFilters(f, conn.filters); err != nil {
+		f.Close()
+		return fmt.Errorf("unable to set filters on %s: %w", conn.cfg.Interface, err)
 	}
+	reader, err := newCANReader(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	conn.file = f
+	conn.reader = reader
+	conn.recv = socketcan.NewReceiver(reader)
+	conn.tx = socketcan.NewTransmitter(canFileConn{f})
 	return nil
 }
 
-func (conn *zConnection) close() {
-	if conn.counter == 0 {
-		return
+func (conn *zConnection) SetFilters(filters []CANFilter) error {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	conn.filters = append([]CANFilter{}, filters...)
+	if conn.file == nil {
+		return nil
+	}
+	return setCANFilters(conn.file, conn.filters)
+}
+
+func (conn *zConnection) Receive() (TimedFrame, error) {
+	conn.mu.Lock()
+	recv, reader := conn.recv, conn.reader
+	conn.mu.Unlock()
+	if recv == nil {
+		return TimedFrame{}, io.EOF
 	}
-	conn.counter -= 1
-	if conn.counter == 0 {
-		conn.conn.Close()
-		conn.conn = nil
+	for recv.Receive() {
+		if !recv.HasErrorFrame() {
+			return TimedFrame{Frame: recv.Frame(), Timestamp: reader.timestamp()}, nil
+		}
+		busOff := recv.ErrorFrame().ErrorClass&socketcan.ErrorClassBusOff != 0
+		conn.mu.Lock()
+		conn.errorFrames++
+		if busOff {
+			conn.busOffs++
+		}
+		conn.mu.Unlock()
+		if busOff {
+			return TimedFrame{}, ErrBusOff
+		}
+	}
+	if err := recv.Err(); err != nil {
+		return TimedFrame{}, err
+	}
+	return TimedFrame{}, io.EOF
+}
+
+func (conn *zConnection) Transmit(ctx context.Context, frame can.Frame) error {
+	conn.mu.Lock()
+	tx := conn.tx
+	conn.mu.Unlock()
+	if tx == nil {
+		return fmt.Errorf("socketcan connection to %s is not open", conn.cfg.Interface)
 	}
+	return tx.TransmitFrame(ctx, frame)
 }
 
-func (conn zConnection) getReceiver() *socketcan.Receiver {
-	return socketcan.NewReceiver(conn.conn)
+func (conn *zConnection) Close() error {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if conn.file == nil {
+		return nil
+	}
+	err := conn.file.Close()
+	conn.file = nil
+	conn.reader = nil
+	conn.recv = nil
+	conn.tx = nil
+	if conn.broughtUp {
+		conn.device.SetDown()
+		conn.broughtUp = false
+	}
+	return err
 }
 
-func (conn zConnection) getTransmitter() *socketcan.Transmitter {
-	return socketcan.NewTransmitter(conn.conn)
+// ControllerStats returns the error frames seen along with the controller
+// state and error counters, which are read via netlink.
+func (conn *zConnection) ControllerStats() (ControllerStats, error) {
+	conn.mu.Lock()
+	cs := ControllerStats{ErrorFrames: conn.errorFrames, BusOffEvents: conn.busOffs}
+	d := conn.device
+	conn.mu.Unlock()
+	if d == nil {
+		var err error
+		if d, err = candevice.New(conn.cfg.Interface); err != nil {
+			// e.g. vcan interfaces have no controller
+			return cs, nil
+		}
+		conn.mu.Lock()
+		conn.device = d
+		conn.mu.Unlock()
+	}
+	var err error
+	cs.State, cs.TxErrorCounter, cs.RxErrorCounter, err = controllerState(d)
+	return cs, err
 }
@@ -4,13 +4,17 @@ import (
 	"bufio"
 	"context"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
 	"os"
 	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"go.einride.tech/can"
+	"golang.org/x/sync/errgroup"
 )
 
 func ZehnderVersionDecode(val uint32) []int {
@@ -24,131 +28,317 @@ type ZehnderDevice struct {
 	NodeID    byte
 	Connected bool
 
+	// mu guards the device information, which is filled in by the first
+	// device-info request.
+	mu              sync.Mutex
 	Model           string
 	SerialNumber    string
 	SoftwareVersion string
 
-	connection zConnection
+	transport         Transport
+	catalog           *SensorCatalog
+	logger            *slog.Logger
+	heartbeatInterval time.Duration
+	listenOnly        bool
+	httpConfig        *HTTPConfig
+
+	cancel             context.CancelFunc
+	group              *errgroup.Group
+	stopped            chan struct{}
+	err                error
+	frameQ             chan TimedFrame
+	pdoQ               chan TimedFrame
+	rmiQ               chan TimedFrame
+	txQ                chan can.Frame
+	heartbeatQ         chan TimedFrame
+	flushQ             chan chan struct{}
+	pdoFlushQ          chan chan struct{}
+	rmiFlushQ          chan chan struct{}
+	rmiRequestQ        chan *ZehnderRMI
+	rmiCTS             chan bool
+	rmiMu              sync.Mutex
+	errorQ             chan *DeviceError
+	queueSizes         QueueSizes
+	store              *PDOStore
+	history            *History
+	subMu              sync.Mutex
+	subscriptions      map[*Subscription]struct{}
+	eventSubscriptions map[*EventSubscription]struct{}
+	nodes              map[byte]time.Time
+	lostNodes          map[byte]bool
+	rmiCbFn            func(*ZehnderRMI)
+	defaultRMICbFn     func(*ZehnderRMI)
+	rmiSequence        atomic.Uint32
+	captureFh          *os.File
+	doCapture          bool
+	http               *http.Server
+	tapMu              sync.Mutex
+	frameTaps          []func(TimedFrame)
+	quit               <-chan struct{}
+	stateMu            sync.Mutex
+	status             ConnectionStatus
+	stateFns           []func(ConnectionState, error)
+	pdoMu              sync.Mutex
+	pdoRequests        map[pdoRequest]byte
+	pdoRequested       map[pdoRequest]time.Time
+	profileMu          sync.Mutex
+	profiles           map[string]Profile
+	profile            string
+	activeProfile      map[pdoRequest]byte
+	rerequestStale     bool
+	filterMu           sync.Mutex
+	extraFilters       []CANFilter
+	stats              busStats
+}
+
+// NewZehnderDevice returns a device using the node ID given, configured by
+// the options supplied.
+func NewZehnderDevice(id byte, opts ...Option) *ZehnderDevice {
+	dev := &ZehnderDevice{
+		NodeID:             id,
+		store:              newPDOStore(),
+		history:            newHistory(defaultHistoryWindow, defaultHistorySamples),
+		subscriptions:      make(map[*Subscription]struct{}),
+		eventSubscriptions: make(map[*EventSubscription]struct{}),
+		catalog:            NewSensorCatalog(),
+		logger:             slog.Default(),
+		errorQ:             make(chan *DeviceError, errorQueueSize),
+		queueSizes:         DefaultQueueSizes,
+		heartbeatInterval:  defaultHeartbeatInterval,
+		pdoRequests:        make(map[pdoRequest]byte),
+		pdoRequested:       make(map[pdoRequest]time.Time),
+		profiles:           DefaultProfiles(),
+		Name:               "Zehnder MVHR",
+	}
+	for _, opt := range opts {
+		opt(dev)
+	}
+	return dev
+}
+
+// SetLogger sets the logger used by the device. Frames are traced at the
+// debug level and lifecycle messages logged at the info level. By default
+// slog.Default() is used.
+func (dev *ZehnderDevice) SetLogger(logger *slog.Logger) {
+	dev.logger = logger
+}
 
-	wg             sync.WaitGroup
-	routines       int
-	stopSignal     chan bool
-	frameQ         chan can.Frame
-	pdoQ           chan can.Frame
-	rmiQ           chan can.Frame
-	txQ            chan can.Frame
-	heartbeatQ     chan can.Frame
-	rmiRequestQ    chan *ZehnderRMI
-	info_syncer    chan bool
-	rmiCTS         chan bool
-	pdoData        map[int]*PDOValue
-	rmiCbFn        func(*ZehnderRMI)
-	defaultRMICbFn func(*ZehnderRMI)
-	rmiSequence    byte
-	captureFh      *os.File
-	doCapture      bool
-	http           *http.Server
+// Store returns the latest values of the PDOs received by the device.
+func (dev *ZehnderDevice) Store() *PDOStore {
+	return dev.store
 }
 
-func NewZehnderDevice(id byte) *ZehnderDevice {
-	return &ZehnderDevice{NodeID: id, pdoData: make(map[int]*PDOValue), Name: "Zehnder MVHR"}
+// History returns the recent values of the PDOs received by the device.
+func (dev *ZehnderDevice) History() *History {
+	return dev.history
+}
+
+// Catalog returns the sensors known to the device.
+func (dev *ZehnderDevice) Catalog() *SensorCatalog {
+	return dev.catalog
 }
 
 func (dev *ZehnderDevice) SetDefaultRMICallback(fn func(*ZehnderRMI)) {
 	dev.defaultRMICbFn = fn
 }
 
+// Connect configures the device to use the named interface. See
+// NewTransport for the supported names.
 func (dev *ZehnderDevice) Connect(interfaceName string) error {
-	return dev.connection.open_device(interfaceName)
+	t, err := NewTransport(interfaceName)
+	if err != nil {
+		return err
+	}
+	dev.transport = t
+	return nil
 }
 
-func (dev *ZehnderDevice) Start() error {
-	dev.stopSignal = make(chan bool, 2)
-	dev.frameQ = make(chan can.Frame)
-	dev.pdoQ = make(chan can.Frame)
-	dev.rmiQ = make(chan can.Frame)
-	dev.txQ = make(chan can.Frame)
-	dev.heartbeatQ = make(chan can.Frame)
+// SetTransport configures the device to use the supplied Transport. It must
+// be called before Start.
+func (dev *ZehnderDevice) SetTransport(t Transport) {
+	dev.transport = t
+}
+
+// Start opens the transport and starts the device's goroutines, which run
+// until ctx is cancelled, Stop is called or one of them fails.
+func (dev *ZehnderDevice) Start(ctx context.Context) error {
+	dev.frameQ = make(chan TimedFrame, dev.queueSizes.Frame)
+	dev.pdoQ = make(chan TimedFrame, dev.queueSizes.PDO)
+	dev.rmiQ = make(chan TimedFrame, dev.queueSizes.RMI)
+	dev.txQ = make(chan can.Frame, dev.queueSizes.Transmit)
+	dev.heartbeatQ = make(chan TimedFrame, dev.queueSizes.Heartbeat)
+	dev.flushQ = make(chan chan struct{})
+	dev.pdoFlushQ = make(chan chan struct{})
+	dev.rmiFlushQ = make(chan chan struct{})
 	dev.rmiRequestQ = make(chan *ZehnderRMI)
-	dev.rmiCTS = make(chan bool)
+	dev.rmiCTS = make(chan bool, 1)
+	dev.stats.reset()
+
+	dev.profileMu.Lock()
+	requests, err := dev.profileRequests(dev.profile)
+	dev.profileMu.Unlock()
+	if err != nil {
+		return err
+	}
 
-	go dev.processFrame()
-	go dev.processPDOFrame()
-	go dev.processRMIFrame()
-	go dev.processRMIQueue()
-	go dev.heartbeat()
-	dev.routines = 5
+	if dev.transport != nil {
+		if err := dev.applyFilters(); err != nil {
+			return err
+		}
+		if err := dev.transport.Open(); err != nil {
+			return err
+		}
+		dev.setState(StateConnected, nil)
+	}
 
-	if dev.connection.device != nil {
-		log.Println("Starting network services")
-		// The receiver does not participate in the wait group, so
-		// don't include in the numbers...
-		go dev.receiver()
-		go dev.transmitter()
-		dev.rmiCTS <- true
-		dev.routines = 6
+	ctx, dev.cancel = context.WithCancel(ctx)
+	dev.group, ctx = errgroup.WithContext(ctx)
+	dev.quit = ctx.Done()
+	dev.stopped = make(chan struct{})
+	dev.err = nil
+
+	dev.group.Go(func() error { return dev.processFrame(ctx) })
+	dev.group.Go(func() error { return dev.processPDOFrame(ctx) })
+	dev.group.Go(func() error { return dev.processRMIFrame(ctx) })
+	dev.group.Go(func() error { return dev.processRMIQueue(ctx) })
+	dev.group.Go(func() error { return dev.heartbeat(ctx) })
+
+	if dev.transport != nil {
+		dev.logger.Info("starting network services")
+		dev.group.Go(func() error { return dev.receiver(ctx) })
+		dev.group.Go(func() error { return dev.transmitter(ctx) })
+		dev.profileMu.Lock()
+		dev.applyProfile(nil, requests)
+		dev.activeProfile = requests
+		dev.profileMu.Unlock()
 	}
+	go dev.supervise()
 
+	if dev.httpConfig != nil {
+		if err := dev.startHttp(*dev.httpConfig); err != nil {
+			dev.Stop()
+			return err
+		}
+	}
 	return nil
 }
 
-func (dev *ZehnderDevice) hasNetwork() bool {
-	return dev.connection.conn != nil
+// supervise waits for the device's goroutines to finish, keeping the first
+// error returned by any of them.
+func (dev *ZehnderDevice) supervise() {
+	err := dev.group.Wait()
+	dev.cancel()
+	if err != nil {
+		dev.logger.Error("device stopped", "error", err)
+	}
+	dev.err = err
+	if dev.hasNetwork() {
+		dev.setState(StateDisconnected, err)
+	}
+	close(dev.stopped)
 }
 
-func (dev *ZehnderDevice) StartHttpServer(host string, port int) {
-	go dev.startHttpServer(host, port)
-	dev.routines++
+// running reports whether the device has been started and not stopped.
+func (dev *ZehnderDevice) running() bool {
+	if dev.quit == nil {
+		return false
+	}
+	select {
+	case <-dev.quit:
+		return false
+	default:
+		return true
+	}
 }
 
-func (dev *ZehnderDevice) storeDeviceInfo(rmi *ZehnderRMI) {
-	tmp, err := rmi.GetData(CN_STRING)
+// goroutine runs fn as one of the device's goroutines, returning false if
+// the device isn't running.
+func (dev *ZehnderDevice) goroutine(fn func() error) bool {
+	if dev.group == nil || !dev.running() {
+		return false
+	}
+	dev.group.Go(fn)
+	return true
+}
+
+func (dev *ZehnderDevice) hasNetwork() bool {
+	return dev.transport != nil
+}
+
+// storeDeviceInfo stores the serial number, software version and model
+// from the reply to the request made by getDeviceInfo, returning false if
+// they couldn't be decoded.
+func (dev *ZehnderDevice) storeDeviceInfo(rmi *ZehnderRMI) bool {
+	serial, err := rmi.GetData(CN_STRING)
 	if err != nil {
-		log.Printf("unable to get device serial number: %s", err)
-		dev.info_syncer <- false
-		return
+		dev.reportError(ErrorDecode, "device-info", fmt.Errorf("serial number: %w", err))
+		return false
 	}
-	dev.SerialNumber = tmp.(string)
-	tmp, err = rmi.GetData(CN_VERSION)
+	version, err := rmi.GetData(CN_VERSION)
 	if err != nil {
-		log.Printf("unable to get software version from device: %s", err)
-		dev.info_syncer <- false
-		return
+		dev.reportError(ErrorDecode, "device-info", fmt.Errorf("software version: %w", err))
+		return false
 	}
-	dev.SoftwareVersion = tmp.(string)
-	tmp, err = rmi.GetData(CN_STRING)
+	model, err := rmi.GetData(CN_STRING)
 	if err != nil {
-		log.Printf("unable to get device model description: %s", err)
-		dev.info_syncer <- false
-		return
+		dev.reportError(ErrorDecode, "device-info", fmt.Errorf("model description: %w", err))
+		return false
 	}
-	dev.Model = tmp.(string)
-	dev.info_syncer <- true
+	dev.mu.Lock()
+	dev.SerialNumber = serial.(string)
+	dev.SoftwareVersion = version.(string)
+	dev.Model = model.(string)
+	dev.mu.Unlock()
+	return true
 }
 
-func (dev *ZehnderDevice) getDeviceInfo(syncer chan bool) {
+// getDeviceInfo requests the device information from the ventilation unit.
+// The returned channel receives the result of storing the reply, unless
+// ctx is done before it arrives.
+func (dev *ZehnderDevice) getDeviceInfo(ctx context.Context) <-chan bool {
+	reply := make(chan bool, 1)
 	dest := NewZehnderDestination(1, 1, 1)
-	dest.GetMultiple(dev, []byte{4, 6, 8}, ZehnderRMITypeActualValue, dev.storeDeviceInfo)
-	dev.info_syncer = syncer
+	dest.GetMultiple(dev, []byte{4, 6, 8}, ZehnderRMITypeActualValue, func(rmi *ZehnderRMI) {
+		ok := dev.storeDeviceInfo(rmi)
+		select {
+		case reply <- ok:
+		case <-ctx.Done():
+		default:
+		}
+	})
+	return reply
 }
 
-func (dev *ZehnderDevice) Wait() {
-	dev.wg.Wait()
+// deviceInfo returns the model, serial number and software version, which
+// are empty until they have been requested.
+func (dev *ZehnderDevice) deviceInfo() (model, serial, version string) {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	return dev.Model, dev.SerialNumber, dev.SoftwareVersion
 }
 
-func (dev *ZehnderDevice) Stop() {
-	if dev.http != nil {
-		dev.http.Shutdown(context.Background())
+// Wait blocks until the device has stopped, returning the error that
+// caused it to stop, if any.
+func (dev *ZehnderDevice) Wait() error {
+	if dev.stopped == nil {
+		return nil
 	}
-	for n := 0; n < dev.routines; n++ {
-		dev.stopSignal <- true
+	<-dev.stopped
+	return dev.err
+}
+
+// Stop stops the device and waits for its goroutines to finish.
+func (dev *ZehnderDevice) Stop() {
+	if dev.cancel == nil {
+		return
 	}
+	dev.cancel()
+	<-dev.stopped
 }
 
 func (dev *ZehnderDevice) CaptureAll(fn string) error {
 	f, err := os.Create(fn)
 	if err != nil {
-		fmt.Println(err)
 		return err
 	}
 	dev.captureFh = f
@@ -156,38 +346,52 @@ func (dev *ZehnderDevice) CaptureAll(fn string) error {
 	return nil
 }
 
-func (dev *ZehnderDevice) ProcessDumpFile(filename string) (err error) {
+func (dev *ZehnderDevice) ProcessDumpFile(filename string) error {
 	info, err := os.Stat(filename)
-	if os.IsNotExist(err) {
-		fmt.Printf("File does not exist: %s. Error: %s\n", filename, err)
+	if err != nil {
 		return err
 	}
-	fmt.Printf("File: %s. Total size is %v bytes\n", filename, info.Size())
+	dev.logger.Info("processing dump file", "file", filename, "size", info.Size())
 	if info.Size() == 0 {
-		fmt.Println("File has 0 bytes. Nothing to do")
-		return fmt.Errorf("file has zero size. Nothing to do")
+		return fmt.Errorf("file %s has zero size. Nothing to do", filename)
 	}
 
 	readFile, err := os.Open(filename)
-
 	if err != nil {
-		fmt.Println(err)
 		return err
 	}
+	defer readFile.Close()
 	fileScanner := bufio.NewScanner(readFile)
 
 	fileScanner.Split(bufio.ScanLines)
 
+	frames := 0
 	for fileScanner.Scan() {
-		fmt.Print(".")
-		frame := can.Frame{}
-		frame.UnmarshalString(fileScanner.Text())
-		dev.frameQ <- frame
+		frame, err := parseCaptureLine(fileScanner.Text())
+		if err != nil {
+			dev.logger.Debug("skipping capture line", "line", fileScanner.Text(), "error", err)
+			continue
+		}
+		if frame.Timestamp.IsZero() {
+			frame.Timestamp = time.Now()
+		}
+		select {
+		case dev.frameQ <- frame:
+		case <-dev.quit:
+			return fmt.Errorf("device stopped while processing %s", filename)
+		}
+		frames++
+		// Don't let the replay overrun the PDO queue, which drops the
+		// oldest frames when full.
+		if frames%cap(dev.pdoQ) == 0 && !dev.flush() {
+			return fmt.Errorf("device stopped while processing %s", filename)
+		}
 	}
-	fmt.Println()
-
-	readFile.Close()
-	return err
+	if !dev.flush() {
+		return fmt.Errorf("device stopped while processing %s", filename)
+	}
+	dev.logger.Info("processed dump file", "file", filename, "frames", frames)
+	return fileScanner.Err()
 }
 
 type pair struct {
@@ -200,22 +404,41 @@ func (p pairList) Len() int           { return len(p) }
 func (p pairList) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
 func (p pairList) Less(i, j int) bool { return p[i].value.Sensor.Name < p[j].value.Sensor.Name }
 
-func (dev *ZehnderDevice) DumpPDO() {
-	p := make(pairList, len(dev.pdoData))
-	i := 0
-
-	for k, v := range dev.pdoData {
-		p[i] = pair{k, v}
-		i++
+// DumpPDO writes a table of the current PDO values to w.
+func (dev *ZehnderDevice) DumpPDO(w io.Writer) {
+	values := dev.store.Snapshot()
+	p := make(pairList, len(values))
+	for i := range values {
+		p[i] = pair{values[i].ID, &values[i]}
 	}
 
 	sort.Sort(p)
 
-	fmt.Println()
-	fmt.Println("ID   Name                                         Raw Data     Value Units")
-	fmt.Println("---- -------------------------------------------- ---------- ------- ---------")
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "%-78s %-14s %s\n", "ID   Name                                         Raw Data     Value Units", "Updated", "Trend (1h)")
+	fmt.Fprintln(w, "---- -------------------------------------------- ---------- ------- --------- -------------- ----------")
 	for _, k := range p {
-		fmt.Printf("%3d  %s\n", k.key, k.value)
+		updated := k.value.Updated.Format("15:04:05")
+		if k.value.Stale {
+			updated += " stale"
+		}
+		fmt.Fprintf(w, "%3d  %-73s %-14s %s\n", k.key, k.value, updated, dev.trend(k.value))
+	}
+	fmt.Fprintln(w)
+}
+
+// trend describes how the PDO has changed over the hour before its last
+// update, giving the direction and range of its values.
+func (dev *ZehnderDevice) trend(pv *PDOValue) string {
+	sm, ck := dev.history.Summary(pv.ID, pv.Updated.Add(-time.Hour), pv.Updated.Add(time.Nanosecond))
+	if !ck || sm.Count < 2 {
+		return ""
+	}
+	arrow := "→"
+	if sm.Last > sm.First {
+		arrow = "↑"
+	} else if sm.Last < sm.First {
+		arrow = "↓"
 	}
-	fmt.Println()
+	return fmt.Sprintf("%s %g to %g", arrow, sm.Min, sm.Max)
 }
@@ -0,0 +1,115 @@
+package zcan
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDeviceInfo(t *testing.T) {
+	_, dev := startSimulated(t)
+
+	// replies nobody waits for must not hold up the device
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i := 0; i < 4; i++ {
+		dev.getDeviceInfo(ctx)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			dev.jsonDeviceInfo(w, httptest.NewRequest(http.MethodGet, "/device-info", nil))
+			if w.Code != http.StatusOK {
+				t.Errorf("device-info returned %d: %s", w.Code, w.Body)
+				return
+			}
+			var info map[string]string
+			if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+				t.Error(err)
+				return
+			}
+			if info["serial_number"] != "SIT0000000000" || info["model"] != "ComfoAir Q450 GB ST ERV" || info["software_version"] != "3.1" {
+				t.Errorf("unexpected device info %v", info)
+			}
+		}()
+	}
+	wg.Wait()
+
+	stopped := make(chan struct{})
+	go func() {
+		dev.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("device didn't stop")
+	}
+}
+
+func TestProcessDumpFile(t *testing.T) {
+	lines := []string{
+		"(1700000000.000000) zcan 10000001#",
+		"(1700000000.100000) zcan 001E0041#FA00",
+		"(1700000000.200000) zcan 001E4041#3D08",
+		"001D4041#38",
+	}
+	// enough repeats to fill the PDO queue several times over, ending
+	// with a supply fan flow of 300
+	for i := 0; i < 4*DefaultQueueSizes.PDO; i++ {
+		lines = append(lines, fmt.Sprintf("(1700000001.%06d) zcan 001E0041#%02X01", i, i%256))
+	}
+	lines = append(lines, "(1700000002.000000) zcan 001E0041#2C01")
+	// RMI responses from node 1 to node 55
+	const rmiFrames = 200
+	for i := 0; i < rmiFrames; i++ {
+		lines = append(lines, fmt.Sprintf("(1700000003.%06d) zcan 1F000DC1#%02X", i, i))
+	}
+	filename := filepath.Join(t.TempDir(), "capture.log")
+	if err := os.WriteFile(filename, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	dev := NewZehnderDevice(55, WithLogger(testLogger))
+	var rmis atomic.Int32
+	dev.SetDefaultRMICallback(func(*ZehnderRMI) {
+		// slow enough for responses to still be queued at the end
+		time.Sleep(100 * time.Microsecond)
+		rmis.Add(1)
+	})
+	if err := dev.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := dev.ProcessDumpFile(filename); err != nil {
+		t.Fatal(err)
+	}
+	received := rmis.Load()
+	dev.Stop()
+
+	values := dev.Store().Snapshot()
+	if len(values) != 3 {
+		t.Fatalf("%d sensors stored, expected 3", len(values))
+	}
+	if pv, ck := dev.Store().GetBySlug("supply_fan_flow"); !ck || pv.Data().Int != 300 {
+		t.Errorf("supply fan flow is %+v, expected 300", pv)
+	}
+	if dropped := dev.stats.drops()["pdo"]; dropped != 0 {
+		t.Errorf("%d PDO frames dropped during replay", dropped)
+	}
+	if received != rmiFrames {
+		t.Errorf("%d RMI responses handled, expected %d", received, rmiFrames)
+	}
+}
@@ -0,0 +1,78 @@
+package zcan
+
+import (
+	"fmt"
+	"time"
+)
+
+const errorQueueSize = 64
+
+// rmiResponseTimeout is how long to wait for the response to an RMI
+// request before sending the next one.
+const rmiResponseTimeout = 5 * time.Second
+
+// ErrorKind classifies the errors reported by a device.
+type ErrorKind int
+
+const (
+	// ErrorTransport is a failure to send or receive frames.
+	ErrorTransport ErrorKind = iota
+	// ErrorProtocol is an error response from another node.
+	ErrorProtocol
+	// ErrorDecode is data that couldn't be decoded.
+	ErrorDecode
+	// ErrorTimeout is a request that wasn't answered in time.
+	ErrorTimeout
+	// ErrorIO is a failure of one of the device's servers or files.
+	ErrorIO
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case ErrorTransport:
+		return "transport"
+	case ErrorProtocol:
+		return "protocol"
+	case ErrorDecode:
+		return "decode"
+	case ErrorTimeout:
+		return "timeout"
+	case ErrorIO:
+		return "io"
+	}
+	return "unknown"
+}
+
+// DeviceError is an error that occurred while the device was running. Op
+// describes what the device was doing, e.g. transmit or rmi.
+type DeviceError struct {
+	Kind ErrorKind
+	Op   string
+	Time time.Time
+	Err  error
+}
+
+func (e *DeviceError) Error() string {
+	return fmt.Sprintf("%s error during %s: %s", e.Kind, e.Op, e.Err)
+}
+
+func (e *DeviceError) Unwrap() error {
+	return e.Err
+}
+
+// Errors returns the channel on which errors are reported while the device
+// is running. Errors are counted in the stats and logged whether or not
+// they are read from the channel, but are dropped if it is full.
+func (dev *ZehnderDevice) Errors() <-chan *DeviceError {
+	return dev.errorQ
+}
+
+func (dev *ZehnderDevice) reportError(kind ErrorKind, op string, err error) {
+	de := &DeviceError{Kind: kind, Op: op, Time: time.Now(), Err: err}
+	dev.logger.Warn("device error", "kind", kind, "op", op, "error", err)
+	dev.stats.error(kind)
+	select {
+	case dev.errorQ <- de:
+	default:
+	}
+}
@@ -0,0 +1,184 @@
+package zcan
+
+import (
+	"sync"
+	"time"
+)
+
+const eventQueueSize = 256
+
+// nodeLostTimeout is how long a node's heartbeat can be missing for before
+// it's lost. It's a variable so tests can shorten it.
+var nodeLostTimeout = 10 * time.Second
+
+type EventType int
+
+const (
+	// EventPDO is a PDO value received, with Node, PDO and Update set.
+	EventPDO EventType = iota
+	// EventPDORequest is a change to the PDOs requested by the device, with
+	// Node, PDO and Interval set. An interval of 0 cancels a request.
+	EventPDORequest
+	// EventNodeSeen is the first heartbeat seen from a node, or the first
+	// after it was lost.
+	EventNodeSeen
+	// EventNodeLost is sent when no heartbeat has been seen from a node for
+	// nodeLostTimeout.
+	EventNodeLost
+	// EventRMIRequest is an RMI request sent by the device.
+	EventRMIRequest
+	// EventRMIResponse is an RMI response received by the device.
+	EventRMIResponse
+	// EventRMIError is an RMI error response received by the device.
+	EventRMIError
+	// EventUnknownFrame is a frame that isn't part of the protocol, with
+	// Frame set.
+	EventUnknownFrame
+	// EventConnection is a change of connection state, with State set and
+	// Err giving the reason, if any.
+	EventConnection
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventPDO:
+		return "pdo"
+	case EventPDORequest:
+		return "pdo-request"
+	case EventNodeSeen:
+		return "node-seen"
+	case EventNodeLost:
+		return "node-lost"
+	case EventRMIRequest:
+		return "rmi-request"
+	case EventRMIResponse:
+		return "rmi-response"
+	case EventRMIError:
+		return "rmi-error"
+	case EventUnknownFrame:
+		return "unknown-frame"
+	case EventConnection:
+		return "connection"
+	}
+	return "unknown"
+}
+
+// Event describes something that happened on the bus or to the device.
+// Only the fields relevant to the Type are set.
+type Event struct {
+	Type     EventType
+	Time     time.Time
+	Node     byte
+	PDO      int
+	Update   *PDOUpdate
+	Interval byte
+	RMI      *ZehnderRMI
+	Frame    *TimedFrame
+	State    ConnectionState
+	Err      error
+}
+
+// EventSubscription delivers the events of the types subscribed to on C.
+// As with Subscription, events are dropped if C isn't read quickly enough
+// and C is closed by Close.
+type EventSubscription struct {
+	C <-chan Event
+
+	dev     *ZehnderDevice
+	c       chan Event
+	types   []EventType
+	mu      sync.Mutex
+	dropped uint64
+}
+
+// SubscribeEvents returns an EventSubscription for events of the types
+// given, or all events if none are given.
+func (dev *ZehnderDevice) SubscribeEvents(types ...EventType) *EventSubscription {
+	c := make(chan Event, eventQueueSize)
+	sub := &EventSubscription{C: c, dev: dev, c: c, types: types}
+	dev.subMu.Lock()
+	dev.eventSubscriptions[sub] = struct{}{}
+	dev.subMu.Unlock()
+	return sub
+}
+
+// Close stops delivery of events and closes C.
+func (sub *EventSubscription) Close() {
+	sub.dev.subMu.Lock()
+	defer sub.dev.subMu.Unlock()
+	if _, ck := sub.dev.eventSubscriptions[sub]; !ck {
+		return
+	}
+	delete(sub.dev.eventSubscriptions, sub)
+	close(sub.c)
+}
+
+// Dropped returns the number of events that couldn't be delivered because
+// C was full.
+func (sub *EventSubscription) Dropped() uint64 {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	return sub.dropped
+}
+
+func (sub *EventSubscription) wants(t EventType) bool {
+	if len(sub.types) == 0 {
+		return true
+	}
+	for _, st := range sub.types {
+		if st == t {
+			return true
+		}
+	}
+	return false
+}
+
+func (dev *ZehnderDevice) publishEvent(ev Event) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	dev.subMu.Lock()
+	defer dev.subMu.Unlock()
+	for sub := range dev.eventSubscriptions {
+		if !sub.wants(ev.Type) {
+			continue
+		}
+		select {
+		case sub.c <- ev:
+		default:
+			sub.mu.Lock()
+			sub.dropped++
+			sub.mu.Unlock()
+		}
+	}
+}
+
+// nodeSeen records a heartbeat from a node. The nodes seen are only used
+// by the heartbeat goroutine. A node that reappears has probably restarted,
+// so the PDOs requested from it are requested again.
+func (dev *ZehnderDevice) nodeSeen(node byte, ts time.Time) {
+	if _, ck := dev.nodes[node]; !ck {
+		dev.publishEvent(Event{Type: EventNodeSeen, Time: ts, Node: node})
+		if dev.lostNodes[node] {
+			delete(dev.lostNodes, node)
+			dev.logger.Info("node reappeared", "node", node)
+			dev.goroutine(func() error {
+				dev.resubscribe(node)
+				return nil
+			})
+		}
+	}
+	dev.nodes[node] = time.Now()
+}
+
+// expireNodes sends EventNodeLost for nodes whose heartbeat hasn't been seen
+// recently.
+func (dev *ZehnderDevice) expireNodes(now time.Time) {
+	for node, seen := range dev.nodes {
+		if now.Sub(seen) > nodeLostTimeout {
+			delete(dev.nodes, node)
+			dev.lostNodes[node] = true
+			dev.publishEvent(Event{Type: EventNodeLost, Time: now, Node: node})
+		}
+	}
+}
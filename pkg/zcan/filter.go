@@ -0,0 +1,40 @@
+package zcan
+
+// Receive filters for the frames handled by the device. Only extended
+// frames are used, so the extended flag is always included in the mask.
+var (
+	pdoFilter       = CANFilter{ID: canEFFFlag, Mask: canEFFFlag | canRTRFlag | 0x1F000000}
+	heartbeatFilter = CANFilter{ID: canEFFFlag | 0x10000000, Mask: canEFFFlag | 0x1F000000}
+)
+
+// receiveFilters returns the filters for the frames the device handles,
+// PDO values, heartbeats and RMI messages addressed to the device, along
+// with any added by AddReceiveFilter.
+func (dev *ZehnderDevice) receiveFilters() []CANFilter {
+	rmiFilter := CANFilter{
+		ID:   canEFFFlag | 0x1F000000 | uint32(dev.NodeID&0x3F)<<6,
+		Mask: canEFFFlag | 0x1F000000 | 0x3F<<6,
+	}
+	dev.filterMu.Lock()
+	defer dev.filterMu.Unlock()
+	return append([]CANFilter{pdoFilter, heartbeatFilter, rmiFilter}, dev.extraFilters...)
+}
+
+// AddReceiveFilter allows frames matching the filter to be received in
+// addition to those the device handles. Filters are only applied by
+// transports that implement FilteringTransport, such as socketcan, where
+// they are installed in the kernel.
+func (dev *ZehnderDevice) AddReceiveFilter(filter CANFilter) error {
+	dev.filterMu.Lock()
+	dev.extraFilters = append(dev.extraFilters, filter)
+	dev.filterMu.Unlock()
+	return dev.applyFilters()
+}
+
+func (dev *ZehnderDevice) applyFilters() error {
+	ft, ok := dev.transport.(FilteringTransport)
+	if !ok {
+		return nil
+	}
+	return ft.SetFilters(dev.receiveFilters())
+}
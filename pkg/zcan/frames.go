@@ -1,38 +1,128 @@
 package zcan
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"strconv"
+	"strings"
+	"time"
 )
 
-func (dev *ZehnderDevice) processFrame() {
-	dev.wg.Add(1)
-	defer dev.wg.Done()
+// captureInterface is the interface name written to capture files.
+const captureInterface = "zcan"
+
+// formatCaptureLine formats a frame as a line of a candump log file, e.g.
+// (1680000000.123456) zcan 10000001#
+func formatCaptureLine(frame TimedFrame) string {
+	ts := frame.Timestamp
+	return fmt.Sprintf("(%d.%06d) %s %s", ts.Unix(), ts.Nanosecond()/1000, captureInterface, frame.Frame)
+}
+
+// parseCaptureLine parses a line of a capture file. Older captures hold
+// only the frame, so have no timestamp.
+func parseCaptureLine(line string) (TimedFrame, error) {
+	var frame TimedFrame
+	fields := strings.Fields(line)
+	if len(fields) == 3 && strings.HasPrefix(fields[0], "(") && strings.HasSuffix(fields[0], ")") {
+		secs, usecs, _ := strings.Cut(strings.Trim(fields[0], "()"), ".")
+		s, err := strconv.ParseInt(secs, 10, 64)
+		if err != nil {
+			return frame, fmt.Errorf("invalid timestamp '%s'", fields[0])
+		}
+		us, err := strconv.ParseInt(usecs, 10, 64)
+		if err != nil {
+			return frame, fmt.Errorf("invalid timestamp '%s'", fields[0])
+		}
+		frame.Timestamp = time.Unix(s, us*1000)
+		fields = fields[2:]
+	}
+	if len(fields) != 1 {
+		return frame, fmt.Errorf("invalid capture line '%s'", line)
+	}
+	err := frame.UnmarshalString(fields[0])
+	return frame, err
+}
+
+func (dev *ZehnderDevice) processFrame(ctx context.Context) error {
+	if dev.captureFh != nil {
+		defer dev.captureFh.Close()
+	}
 
-loop:
 	for {
 		select {
 		case frame := <-dev.frameQ:
-			if dev.doCapture {
-				dev.captureFh.WriteString(fmt.Sprintf("%s\n", frame))
+			if !dev.classifyFrame(ctx, frame) {
+				return nil
+			}
+		case done := <-dev.flushQ:
+			// Frames queued before the flush was requested are sorted
+			// before passing it on to the PDO queue.
+			for n := len(dev.frameQ); n > 0; n-- {
+				if !dev.classifyFrame(ctx, <-dev.frameQ) {
+					return nil
+				}
 			}
-			ck := frame.ID >> 24
-			switch ck {
-			case 0:
-				dev.pdoQ <- frame
-			case 0x1F:
-				dev.rmiQ <- frame
-			case 0x10:
-				dev.heartbeatQ <- frame
-			default:
-				log.Printf("Unknown frame MSB: %02X", ck)
+			select {
+			case dev.pdoFlushQ <- done:
+			case <-ctx.Done():
+				return nil
 			}
-		case <-dev.stopSignal:
-			break loop
+		case <-ctx.Done():
+			return nil
 		}
 	}
+}
 
+// classifyFrame captures a received frame and adds it to the queue for its
+// type. It returns false if ctx is done before the frame could be queued.
+func (dev *ZehnderDevice) classifyFrame(ctx context.Context, frame TimedFrame) bool {
 	if dev.doCapture {
-		dev.captureFh.Close()
+		if _, err := dev.captureFh.WriteString(formatCaptureLine(frame) + "\n"); err != nil {
+			dev.reportError(ErrorIO, "capture", err)
+			dev.doCapture = false
+		}
+	}
+	ck := frame.ID >> 24
+	switch ck {
+	case 0:
+		dev.stats.classified(PDOData)
+		if n := pushDropOldest(dev.pdoQ, frame); n > 0 {
+			dev.stats.drop("pdo", n)
+		}
+	case 0x1F:
+		dev.stats.classified(RMI)
+		select {
+		case dev.rmiQ <- frame:
+		case <-ctx.Done():
+			return false
+		}
+	case 0x10:
+		dev.stats.classified(HeartBeat)
+		if n := pushDropOldest(dev.heartbeatQ, frame); n > 0 {
+			dev.stats.drop("heartbeat", n)
+		}
+	default:
+		dev.stats.unknown()
+		dev.logger.Debug("unknown frame", "msb", fmt.Sprintf("%02X", ck), "frame", frame.Frame)
+		dev.publishEvent(Event{Type: EventUnknownFrame, Time: frame.Timestamp, Frame: &frame})
+	}
+	return true
+}
+
+// flush waits until the frames already queued have been sorted and the
+// PDOs and RMIs among them handled. It returns false if the device stops
+// first.
+func (dev *ZehnderDevice) flush() bool {
+	done := make(chan struct{})
+	select {
+	case dev.flushQ <- done:
+	case <-dev.quit:
+		return false
+	}
+	select {
+	case <-done:
+		return true
+	case <-dev.quit:
+		return false
 	}
 }
@@ -1,6 +1,7 @@
 package zcan
 
 import (
+	"context"
 	"time"
 
 	"go.einride.tech/can"
@@ -11,35 +12,41 @@ func (dev *ZehnderDevice) makeHeartbeatFrame() can.Frame {
 	return can.Frame{ID: id, IsExtended: true}
 }
 
-func (dev *ZehnderDevice) heartbeat() {
-	dev.wg.Add(1)
-
+func (dev *ZehnderDevice) heartbeat(ctx context.Context) error {
 	if dev.hasNetwork() {
-		dev.txQ <- dev.makeHeartbeatFrame()
+		dev.sendFrame(dev.makeHeartbeatFrame())
 	}
-	timer := time.NewTicker(2 * time.Second)
+	timer := time.NewTicker(dev.heartbeatInterval)
+	defer timer.Stop()
+	dev.nodes = make(map[byte]time.Time)
+	dev.lostNodes = make(map[byte]bool)
 
-loop:
 	for {
 		select {
 		case frame := <-dev.heartbeatQ:
-			if frame.IsRemote {
+			if !frame.IsRemote {
+				if node := byte(frame.ID & 0x3F); node != dev.NodeID {
+					dev.nodeSeen(node, frame.Timestamp)
+				}
+			} else {
 				nodeId := frame.ID & 0x3F
 				if nodeId == uint32(dev.NodeID) {
 					if dev.hasNetwork() {
-						dev.txQ <- dev.makeHeartbeatFrame()
+						dev.sendFrame(dev.makeHeartbeatFrame())
 					}
-					timer.Reset(2 * time.Second)
+					timer.Reset(dev.heartbeatInterval)
 				}
 			}
-		case <-dev.stopSignal:
-			break loop
+		case <-ctx.Done():
+			return nil
 		case <-timer.C:
 			if dev.hasNetwork() {
-				dev.txQ <- dev.makeHeartbeatFrame()
+				dev.sendFrame(dev.makeHeartbeatFrame())
+			}
+			dev.expireNodes(time.Now())
+			if dev.rerequestStale {
+				dev.requestStale(time.Now())
 			}
 		}
 	}
-	timer.Stop()
-	dev.wg.Done()
 }
@@ -0,0 +1,189 @@
+package zcan
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultHistoryWindow  = 24 * time.Hour
+	defaultHistorySamples = 43200
+)
+
+// Sample is the numeric value of a PDO at a point in time.
+type Sample struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+// Summary describes the samples of a PDO over a period. Series returned by
+// Downsample have one Summary for each step with samples.
+type Summary struct {
+	From  time.Time `json:"from"`
+	To    time.Time `json:"to"`
+	Count int       `json:"count"`
+	Min   float64   `json:"min"`
+	Max   float64   `json:"max"`
+	Mean  float64   `json:"mean"`
+	First float64   `json:"first"`
+	Last  float64   `json:"last"`
+}
+
+// sampleRing holds samples in time order, growing until it reaches its
+// limit and then overwriting the oldest.
+type sampleRing struct {
+	buf   []Sample
+	head  int
+	count int
+	limit int
+}
+
+func (r *sampleRing) at(i int) Sample {
+	return r.buf[(r.head+i)%len(r.buf)]
+}
+
+func (r *sampleRing) push(s Sample) {
+	if r.count == len(r.buf) {
+		if len(r.buf) < r.limit {
+			buf := make([]Sample, min(max(2*len(r.buf), 16), r.limit))
+			for i := 0; i < r.count; i++ {
+				buf[i] = r.at(i)
+			}
+			r.buf, r.head = buf, 0
+		} else {
+			r.head = (r.head + 1) % len(r.buf)
+			r.count--
+		}
+	}
+	r.buf[(r.head+r.count)%len(r.buf)] = s
+	r.count++
+}
+
+// expire drops the samples before the cutoff.
+func (r *sampleRing) expire(cutoff time.Time) {
+	for r.count > 0 && r.buf[r.head].Time.Before(cutoff) {
+		r.head = (r.head + 1) % len(r.buf)
+		r.count--
+	}
+}
+
+// span returns the indexes of the samples from the start of the range up
+// to, but not including, its end.
+func (r *sampleRing) span(from, to time.Time) (int, int) {
+	start := sort.Search(r.count, func(i int) bool { return !r.at(i).Time.Before(from) })
+	end := sort.Search(r.count, func(i int) bool { return !r.at(i).Time.Before(to) })
+	return start, end
+}
+
+func (r *sampleRing) summary(start, end int) Summary {
+	var sm Summary
+	for i := start; i < end; i++ {
+		s := r.at(i)
+		if sm.Count == 0 {
+			sm.From, sm.Min, sm.Max, sm.First = s.Time, s.Value, s.Value, s.Value
+		}
+		sm.Min = min(sm.Min, s.Value)
+		sm.Max = max(sm.Max, s.Value)
+		sm.Mean += s.Value
+		sm.To, sm.Last = s.Time, s.Value
+		sm.Count++
+	}
+	if sm.Count > 0 {
+		sm.Mean /= float64(sm.Count)
+	}
+	return sm
+}
+
+// History keeps the numeric values received for each PDO over a window of
+// time, e.g. the last 24 hours. It is safe for concurrent use.
+type History struct {
+	mu      sync.RWMutex
+	window  time.Duration
+	samples int
+	series  map[int]*sampleRing
+}
+
+func newHistory(window time.Duration, samples int) *History {
+	return &History{window: window, samples: samples, series: make(map[int]*sampleRing)}
+}
+
+// add records a value for the PDO. Samples older than the latest for the
+// PDO are ignored, so that the samples stay in time order.
+func (h *History) add(pdo int, ts time.Time, value float64) {
+	if h.window <= 0 {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	r, ck := h.series[pdo]
+	if !ck {
+		r = &sampleRing{limit: h.samples}
+		h.series[pdo] = r
+	}
+	if r.count > 0 && ts.Before(r.at(r.count-1).Time) {
+		return
+	}
+	r.push(Sample{ts, value})
+	r.expire(ts.Add(-h.window))
+}
+
+// Window returns the length of time samples are kept for.
+func (h *History) Window() time.Duration {
+	return h.window
+}
+
+// Samples returns the samples for the PDO from the start of the range up
+// to its end.
+func (h *History) Samples(pdo int, from, to time.Time) []Sample {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	r, ck := h.series[pdo]
+	if !ck {
+		return nil
+	}
+	start, end := r.span(from, to)
+	rv := make([]Sample, 0, end-start)
+	for i := start; i < end; i++ {
+		rv = append(rv, r.at(i))
+	}
+	return rv
+}
+
+// Summary returns the minimum, maximum, mean, first and last values of the
+// PDO over the range. The boolean is false if there are no samples.
+func (h *History) Summary(pdo int, from, to time.Time) (Summary, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	r, ck := h.series[pdo]
+	if !ck {
+		return Summary{}, false
+	}
+	sm := r.summary(r.span(from, to))
+	return sm, sm.Count > 0
+}
+
+// Downsample divides the range into steps, returning a Summary of the
+// samples in each step that has any.
+func (h *History) Downsample(pdo int, from, to time.Time, step time.Duration) []Summary {
+	if step <= 0 {
+		return nil
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	r, ck := h.series[pdo]
+	if !ck {
+		return nil
+	}
+	var rv []Summary
+	for t := from; t.Before(to); t = t.Add(step) {
+		end := t.Add(step)
+		if end.After(to) {
+			end = to
+		}
+		if sm := r.summary(r.span(t, end)); sm.Count > 0 {
+			rv = append(rv, sm)
+		}
+	}
+	return rv
+}
@@ -0,0 +1,151 @@
+package zcan
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+// ringValues returns the values held by the ring, oldest first.
+func ringValues(r *sampleRing) []float64 {
+	rv := make([]float64, 0, r.count)
+	for i := 0; i < r.count; i++ {
+		rv = append(rv, r.at(i).Value)
+	}
+	return rv
+}
+
+// sequence returns the values from first up to, but not including, last.
+func sequence(first, last int) []float64 {
+	rv := make([]float64, 0, last-first)
+	for i := first; i < last; i++ {
+		rv = append(rv, float64(i))
+	}
+	return rv
+}
+
+func TestSampleRing(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name     string
+		limit    int
+		pushes   int
+		expire   int
+		expected []float64
+	}{
+		{"empty", 5, 0, 0, sequence(0, 0)},
+		{"below limit", 5, 3, 0, sequence(0, 3)},
+		{"at limit", 5, 5, 0, sequence(0, 5)},
+		{"wrapped", 5, 7, 0, sequence(2, 7)},
+		{"wrapped several times", 5, 23, 0, sequence(18, 23)},
+		{"grown", 40, 20, 0, sequence(0, 20)},
+		{"grown and wrapped", 20, 50, 0, sequence(30, 50)},
+		{"expired", 20, 10, 4, sequence(4, 10)},
+		{"wrapped and expired", 8, 20, 15, sequence(15, 20)},
+		{"all expired", 8, 20, 30, sequence(0, 0)},
+		{"expired before the oldest", 8, 20, 5, sequence(12, 20)},
+	} {
+		r := &sampleRing{limit: tc.limit}
+		for i := 0; i < tc.pushes; i++ {
+			r.push(Sample{base.Add(time.Duration(i) * time.Second), float64(i)})
+		}
+		r.expire(base.Add(time.Duration(tc.expire) * time.Second))
+		if got := ringValues(r); !slices.Equal(got, tc.expected) {
+			t.Errorf("%s: ring holds %v, expected %v", tc.name, got, tc.expected)
+		}
+		if len(r.buf) > tc.limit {
+			t.Errorf("%s: ring grew to %d, beyond its limit of %d", tc.name, len(r.buf), tc.limit)
+		}
+	}
+}
+
+func TestHistory(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(s int) time.Time { return base.Add(time.Duration(s) * time.Second) }
+	h := newHistory(time.Minute, 100)
+	for i := 0; i < 10; i++ {
+		h.add(120, at(i), float64(i))
+	}
+	// out of order samples are ignored
+	h.add(120, at(5), 100)
+
+	for _, tc := range []struct {
+		from, to int
+		expected Summary
+	}{
+		{0, 10, Summary{From: at(0), To: at(9), Count: 10, Min: 0, Max: 9, Mean: 4.5, First: 0, Last: 9}},
+		{2, 5, Summary{From: at(2), To: at(4), Count: 3, Min: 2, Max: 4, Mean: 3, First: 2, Last: 4}},
+		{-5, 1, Summary{From: at(0), To: at(0), Count: 1, Min: 0, Max: 0, Mean: 0, First: 0, Last: 0}},
+	} {
+		if got, ck := h.Summary(120, at(tc.from), at(tc.to)); !ck || got != tc.expected {
+			t.Errorf("summary from %d to %d is %+v, expected %+v", tc.from, tc.to, got, tc.expected)
+		}
+	}
+	if _, ck := h.Summary(120, at(10), at(20)); ck {
+		t.Error("summary returned for a range without samples")
+	}
+	if _, ck := h.Summary(121, at(0), at(10)); ck {
+		t.Error("summary returned for a PDO without samples")
+	}
+
+	var counts, means []float64
+	for _, sm := range h.Downsample(120, at(-6), at(10), 3*time.Second) {
+		counts = append(counts, float64(sm.Count))
+		means = append(means, sm.Mean)
+	}
+	// the first two steps have no samples, and the last is cut short
+	if !slices.Equal(counts, []float64{3, 3, 3, 1}) || !slices.Equal(means, []float64{1, 4, 7, 9}) {
+		t.Errorf("downsampled counts %v and means %v, expected [3 3 3 1] and [1 4 7 9]", counts, means)
+	}
+	if got := h.Downsample(120, at(0), at(10), 0); got != nil {
+		t.Errorf("downsampled with no step into %v", got)
+	}
+
+	// samples are expired as new ones are added
+	h.add(120, at(65), 65)
+	if got := h.Samples(120, at(0), at(70)); len(got) != 6 || got[0].Value != 5 {
+		t.Errorf("samples after expiry are %v, expected 5 to 9 and 65", got)
+	}
+}
+
+func TestJSONHistory(t *testing.T) {
+	dev := NewZehnderDevice(55, WithLogger(testLogger))
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		dev.history.add(120, now.Add(time.Duration(i-10)*time.Second), float64(i))
+	}
+	for _, tc := range []struct {
+		query string
+		code  int
+		steps int
+	}{
+		{"sensor=supply_fan_flow", http.StatusOK, 1},
+		{"sensor=supply_fan_flow&period=30ns", http.StatusOK, 0},
+		{"sensor=supply_fan_flow&period=1m&step=30s", http.StatusOK, 1},
+		{"sensor=supply_fan_flow&period=1h&step=1s", http.StatusBadRequest, 0},
+		{"sensor=supply_fan_flow&period=-1h", http.StatusBadRequest, 0},
+		{"sensor=supply_fan_flow&step=0s", http.StatusBadRequest, 0},
+		{"sensor=no_such_sensor", http.StatusNotFound, 0},
+	} {
+		w := httptest.NewRecorder()
+		dev.jsonHistory(w, httptest.NewRequest(http.MethodGet, "/history?"+tc.query, nil))
+		if w.Code != tc.code {
+			t.Errorf("%s: returned %d, expected %d: %s", tc.query, w.Code, tc.code, w.Body)
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		var data struct {
+			Series []Summary `json:"series"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+			t.Errorf("%s: %v", tc.query, err)
+		} else if len(data.Series) != tc.steps {
+			t.Errorf("%s: %d steps returned, expected %d", tc.query, len(data.Series), tc.steps)
+		}
+	}
+}
@@ -1,35 +1,73 @@
 package zcan
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"net"
 	"net/http"
+	"strings"
+	"time"
 )
 
-func (dev *ZehnderDevice) startHttpServer(host string, port int) {
-	log.Printf("Starting HTTP server listening @ http://%s:%d/", host, port)
+// RegisterHandlers adds the device's HTTP endpoints to mux under the
+// prefix given, e.g. /unit1, allowing several devices to share a server.
+// An empty prefix serves the endpoints from the root.
+func (dev *ZehnderDevice) RegisterHandlers(mux *http.ServeMux, prefix string) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	mux.HandleFunc(prefix+"/", dev.jsonResponse)
+	mux.HandleFunc(prefix+"/device-info", dev.jsonDeviceInfo)
+	mux.HandleFunc(prefix+"/dump", dev.dumpPDO)
+	mux.HandleFunc(prefix+"/sensors", dev.jsonSensors)
+	mux.HandleFunc(prefix+"/status", dev.jsonStatus)
+	mux.HandleFunc(prefix+"/stats", dev.jsonStats)
+	mux.HandleFunc(prefix+"/profile", dev.jsonProfile)
+	mux.HandleFunc(prefix+"/history", dev.jsonHistory)
+}
+
+// StartHttpServer starts serving the device's endpoints on the host and
+// port given. It should be called once the device has been started and the
+// server runs until the device is stopped.
+func (dev *ZehnderDevice) StartHttpServer(host string, port int) error {
+	return dev.startHttp(HTTPConfig{Host: host, Port: port})
+}
+
+func (dev *ZehnderDevice) startHttp(config HTTPConfig) error {
+	l, err := net.Listen("tcp", fmt.Sprintf("%s:%d", config.Host, config.Port))
+	if err != nil {
+		return err
+	}
 	mux := http.NewServeMux()
-	mux.HandleFunc("/", dev.jsonResponse)
-	mux.HandleFunc("/device-info", dev.jsonDeviceInfo)
-	mux.HandleFunc("/dump", dev.dumpPDO)
+	dev.RegisterHandlers(mux, config.Prefix)
+	dev.http = &http.Server{Handler: mux}
+	if !dev.goroutine(func() error { return dev.serveHttp(l) }) {
+		l.Close()
+		return fmt.Errorf("HTTP server requires the device to be running")
+	}
+	dev.logger.Info("starting HTTP server", "address", fmt.Sprintf("http://%s:%d%s/", config.Host, config.Port, strings.TrimSuffix(config.Prefix, "/")))
+	return nil
+}
 
-	dev.http = &http.Server{Addr: fmt.Sprintf("%s:%d", host, port), Handler: mux}
-	dev.wg.Add(1)
-	err := dev.http.ListenAndServe()
+func (dev *ZehnderDevice) serveHttp(l net.Listener) error {
+	go func() {
+		<-dev.quit
+		dev.http.Shutdown(context.Background())
+	}()
+	err := dev.http.Serve(l)
 	if err == http.ErrServerClosed {
-		log.Println("HTTP server shutdown")
-		dev.wg.Done()
-	} else if err != nil {
-		log.Printf("server error: %v\n", err)
+		dev.logger.Info("HTTP server shutdown")
+		return nil
 	}
+	err = fmt.Errorf("HTTP server: %w", err)
+	dev.reportError(ErrorIO, "http", err)
+	return err
 }
 
 func (dev *ZehnderDevice) jsonResponse(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	dataMap := make(map[string]interface{})
 
-	for _, v := range dev.pdoData {
+	for _, v := range dev.store.Snapshot() {
 		dataMap[v.Sensor.slug] = v.GetData()
 	}
 
@@ -38,30 +76,186 @@ func (dev *ZehnderDevice) jsonResponse(w http.ResponseWriter, r *http.Request) {
 		w.Write(outData)
 		return
 	}
-	log.Printf("jsonResponse: Unable to generate json data: %s", err)
+	dev.logger.Error("unable to generate json data", "handler", "jsonResponse", "error", err)
 }
 
 func (dev *ZehnderDevice) jsonDeviceInfo(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	log.Printf("dev.SerialNumber: %s", dev.SerialNumber)
-	if dev.SerialNumber == "" {
-		syncer := make(chan bool)
-		dev.getDeviceInfo(syncer)
-		<-syncer
+	model, serial, version := dev.deviceInfo()
+	if serial == "" {
+		ctx, cancel := context.WithTimeout(r.Context(), rmiResponseTimeout+time.Second)
+		defer cancel()
+		select {
+		case <-dev.getDeviceInfo(ctx):
+		case <-ctx.Done():
+			http.Error(w, "no response from device", http.StatusGatewayTimeout)
+			return
+		}
+		model, serial, version = dev.deviceInfo()
 	}
 	dataMap := make(map[string]interface{})
-	dataMap["model"] = dev.Model
-	dataMap["serial_number"] = dev.SerialNumber
-	dataMap["software_version"] = dev.SoftwareVersion
+	dataMap["model"] = model
+	dataMap["serial_number"] = serial
+	dataMap["software_version"] = version
+
+	outData, err := json.Marshal(dataMap)
+	if err == nil {
+		w.Write(outData)
+		return
+	}
+	dev.logger.Error("unable to generate json data", "handler", "jsonDeviceInfo", "error", err)
+}
+
+func (dev *ZehnderDevice) jsonSensors(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	dataMap := make(map[string]interface{})
+
+	for _, v := range dev.store.Snapshot() {
+		dataMap[v.Sensor.slug] = map[string]interface{}{
+			"id":          v.ID,
+			"name":        v.Sensor.Name,
+			"value":       v.GetData(),
+			"units":       v.Sensor.Units,
+			"description": v.Sensor.Description,
+			"updated":     v.Updated,
+			"interval":    v.Interval.Seconds(),
+			"stale":       v.Stale,
+		}
+	}
 
 	outData, err := json.Marshal(dataMap)
 	if err == nil {
 		w.Write(outData)
 		return
 	}
-	log.Printf("jsonDeviceInfo: Unable to generate json data: %s", err)
+	dev.logger.Error("unable to generate json data", "handler", "jsonSensors", "error", err)
 }
 
 func (dev *ZehnderDevice) dumpPDO(w http.ResponseWriter, r *http.Request) {
-	dev.DumpPDO()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	dev.DumpPDO(w)
+}
+
+// jsonProfile reports the current profile. A POST with the name of a
+// profile changes it, with an empty name removing the profile.
+func (dev *ZehnderDevice) jsonProfile(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if err := dev.SetProfile(r.FormValue("name")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	dataMap := make(map[string]interface{})
+	dataMap["profile"] = dev.Profile()
+	dataMap["profiles"] = dev.Profiles()
+
+	outData, err := json.Marshal(dataMap)
+	if err == nil {
+		w.Write(outData)
+		return
+	}
+	dev.logger.Error("unable to generate json data", "handler", "jsonProfile", "error", err)
+}
+
+// maxHistorySteps limits the number of steps a history request can be
+// divided into.
+const maxHistorySteps = 1000
+
+// minHistoryStep is the shortest step used when none is given.
+const minHistoryStep = time.Second
+
+// jsonHistory summarises the values received over the period given, which
+// defaults to the last hour. With a sensor slug it also returns the values
+// of the sensor downsampled into steps, 60 of at least a second by default.
+func (dev *ZehnderDevice) jsonHistory(w http.ResponseWriter, r *http.Request) {
+	period := time.Hour
+	if v := r.FormValue("period"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			http.Error(w, fmt.Sprintf("invalid period '%s'", v), http.StatusBadRequest)
+			return
+		}
+		period = d
+	}
+	step := max(period/60, minHistoryStep)
+	if v := r.FormValue("step"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			http.Error(w, fmt.Sprintf("invalid step '%s'", v), http.StatusBadRequest)
+			return
+		}
+		step = d
+	}
+	if period/step > maxHistorySteps {
+		http.Error(w, fmt.Sprintf("period can be divided into at most %d steps", maxHistorySteps), http.StatusBadRequest)
+		return
+	}
+	to := time.Now()
+	from := to.Add(-period)
+
+	dataMap := make(map[string]interface{})
+	if slug := r.FormValue("sensor"); slug != "" {
+		pdo, sensor, ck := dev.catalog.SensorBySlug(slug)
+		if !ck {
+			http.Error(w, fmt.Sprintf("no matching PDO found for '%s'", slug), http.StatusNotFound)
+			return
+		}
+		dataMap["id"] = pdo
+		dataMap["name"] = sensor.Name
+		dataMap["units"] = sensor.Units
+		if sm, ck := dev.history.Summary(pdo, from, to); ck {
+			dataMap["summary"] = sm
+		}
+		dataMap["series"] = dev.history.Downsample(pdo, from, to, step)
+	} else {
+		for _, v := range dev.store.Snapshot() {
+			if sm, ck := dev.history.Summary(v.ID, from, to); ck {
+				dataMap[v.Sensor.slug] = sm
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	outData, err := json.Marshal(dataMap)
+	if err == nil {
+		w.Write(outData)
+		return
+	}
+	dev.logger.Error("unable to generate json data", "handler", "jsonHistory", "error", err)
+}
+
+func (dev *ZehnderDevice) jsonStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	status := dev.Status()
+	dataMap := make(map[string]interface{})
+	dataMap["state"] = status.State.String()
+	dataMap["since"] = status.Since
+	dataMap["reconnects"] = status.Reconnects
+	if status.LastError != nil {
+		dataMap["last_error"] = status.LastError.Error()
+	}
+
+	outData, err := json.Marshal(dataMap)
+	if err == nil {
+		w.Write(outData)
+		return
+	}
+	dev.logger.Error("unable to generate json data", "handler", "jsonStatus", "error", err)
+}
+
+func (dev *ZehnderDevice) jsonStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	outData, err := json.Marshal(dev.Stats())
+	if err == nil {
+		w.Write(outData)
+		return
+	}
+	dev.logger.Error("unable to generate json data", "handler", "jsonStats", "error", err)
 }
@@ -3,42 +3,83 @@ package zcan
 import (
 	"context"
 	"fmt"
+	"time"
 )
 
-func (dev *ZehnderDevice) receiver() {
-	err := dev.connection.open()
-	if err != nil {
-		fmt.Println(err)
-		return
-	}
+// addFrameTap registers a function that is called with every frame received
+// from or transmitted to the bus. The function must not block.
+func (dev *ZehnderDevice) addFrameTap(fn func(TimedFrame)) {
+	dev.tapMu.Lock()
+	dev.frameTaps = append(dev.frameTaps, fn)
+	dev.tapMu.Unlock()
+}
 
-	recv := dev.connection.getReceiver()
-	for recv.Receive() {
-		frame := recv.Frame()
-		dev.frameQ <- frame
+func (dev *ZehnderDevice) tapFrame(frame TimedFrame) {
+	dev.tapMu.Lock()
+	defer dev.tapMu.Unlock()
+	for _, fn := range dev.frameTaps {
+		fn(frame)
 	}
-	dev.connection.close()
 }
 
-func (dev *ZehnderDevice) transmitter() {
-	err := dev.connection.open()
-	if err != nil {
-		fmt.Println(err)
-		return
+// receiver reads frames from the transport, reconnecting if it fails. The
+// transport is closed by the transmitter when the device is stopped, which
+// causes the receiver to return, and by the receiver as it returns in case
+// a reconnect had reopened it.
+func (dev *ZehnderDevice) receiver(ctx context.Context) error {
+	defer dev.transport.Close()
+
+	for {
+		frame, err := dev.transport.Receive()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			dev.reportError(ErrorTransport, "receive", err)
+			if !dev.reconnect(err) {
+				return nil
+			}
+			continue
+		}
+		dev.logger.Debug("frame received", "frame", frame.Frame, "time", frame.Timestamp)
+		dev.stats.received(frame.Frame)
+		dev.tapFrame(frame)
+		select {
+		case dev.frameQ <- frame:
+		case <-ctx.Done():
+			return nil
+		}
 	}
+}
+
+// transmitTimeout bounds how long a frame may take to be sent, so that a
+// stalled bus can't hold up the transmitter.
+const transmitTimeout = 2 * time.Second
 
-	dev.wg.Add(1)
+func (dev *ZehnderDevice) transmitter(ctx context.Context) error {
+	defer dev.transport.Close()
 
-	tx := dev.connection.getTransmitter()
-loop:
 	for {
 		select {
 		case frame := <-dev.txQ:
-			tx.TransmitFrame(context.Background(), frame)
-		case <-dev.stopSignal:
-			break loop
+			txCtx, cancel := context.WithTimeout(ctx, transmitTimeout)
+			err := dev.transport.Transmit(txCtx, frame)
+			cancel()
+			if err != nil {
+				dev.stats.txError()
+				dev.reportError(ErrorTransport, "transmit", fmt.Errorf("frame %s: %w", frame, err))
+				// Closing the transport causes the receiver to reconnect.
+				if dev.Status().State == StateConnected {
+					dev.setState(StateReconnecting, err)
+					dev.transport.Close()
+				}
+			} else {
+				dev.logger.Debug("frame transmitted", "frame", frame)
+				dev.stats.transmitted(frame)
+				dev.tapFrame(TimedFrame{Frame: frame, Timestamp: time.Now()})
+			}
+		case <-ctx.Done():
+			return nil
 		}
 	}
-	dev.connection.close()
-	dev.wg.Done()
 }
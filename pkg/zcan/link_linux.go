@@ -0,0 +1,79 @@
+//go:build linux
+
+package zcan
+
+import (
+	"fmt"
+	"net"
+	"unsafe"
+
+	"github.com/mdlayher/netlink"
+	"go.einride.tech/can/pkg/candevice"
+	"golang.org/x/sys/unix"
+)
+
+func linkRestartMs(d *candevice.Device) (uint32, error) {
+	info, err := d.Info()
+	if err != nil {
+		return 0, err
+	}
+	return info.RestartMs, nil
+}
+
+var controllerStateNames = map[uint32]string{
+	candevice.StateErrorActive:  "error-active",
+	candevice.StateErrorWarning: "error-warning",
+	candevice.StateErrorPassive: "error-passive",
+	candevice.StateBusOff:       "bus-off",
+	candevice.StateStopped:      "stopped",
+	candevice.StateSleeping:     "sleeping",
+}
+
+func controllerState(d *candevice.Device) (string, uint16, uint16, error) {
+	info, err := d.Info()
+	if err != nil {
+		return "", 0, 0, err
+	}
+	return controllerStateNames[info.State], info.BusErrorCounters.Txerr, info.BusErrorCounters.Rxerr, nil
+}
+
+// setLinkRestartMs sets the delay before a CAN controller is automatically
+// restarted after going bus-off, as "ip link set can0 type can restart-ms".
+// A value of 0 disables the automatic restart.
+func setLinkRestartMs(interfaceName string, ms uint32) error {
+	ifi, err := net.InterfaceByName(interfaceName)
+	if err != nil {
+		return err
+	}
+	c, err := netlink.Dial(unix.NETLINK_ROUTE, &netlink.Config{})
+	if err != nil {
+		return fmt.Errorf("couldn't dial netlink socket: %w", err)
+	}
+	defer c.Close()
+
+	msg := unix.IfInfomsg{Family: unix.AF_UNSPEC, Index: int32(ifi.Index)}
+	data := append([]byte{}, (*[unix.SizeofIfInfomsg]byte)(unsafe.Pointer(&msg))[:]...)
+
+	ae := netlink.NewAttributeEncoder()
+	ae.Nested(unix.IFLA_LINKINFO, func(nae *netlink.AttributeEncoder) error {
+		nae.String(unix.IFLA_INFO_KIND, "can")
+		nae.Nested(unix.IFLA_INFO_DATA, func(dae *netlink.AttributeEncoder) error {
+			dae.Uint32(unix.IFLA_CAN_RESTART_MS, ms)
+			return nil
+		})
+		return nil
+	})
+	attrs, err := ae.Encode()
+	if err != nil {
+		return fmt.Errorf("couldn't encode message: %w", err)
+	}
+
+	req := netlink.Message{
+		Header: netlink.Header{Type: unix.RTM_NEWLINK, Flags: netlink.Request | netlink.Acknowledge},
+		Data:   append(data, attrs...),
+	}
+	if _, err := c.Execute(req); err != nil {
+		return fmt.Errorf("couldn't set restart-ms: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+//go:build !linux
+
+package zcan
+
+import (
+	"fmt"
+
+	"go.einride.tech/can/pkg/candevice"
+)
+
+func linkRestartMs(d *candevice.Device) (uint32, error) {
+	return 0, fmt.Errorf("reading restart-ms is only supported on linux")
+}
+
+func controllerState(d *candevice.Device) (string, uint16, uint16, error) {
+	return "", 0, 0, fmt.Errorf("reading the controller state is only supported on linux")
+}
+
+func setLinkRestartMs(interfaceName string, ms uint32) error {
+	return fmt.Errorf("setting restart-ms is only supported on linux")
+}
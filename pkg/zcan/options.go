@@ -0,0 +1,108 @@
+package zcan
+
+import (
+	"log/slog"
+	"time"
+)
+
+const defaultHeartbeatInterval = 2 * time.Second
+
+// Option configures a ZehnderDevice created by NewZehnderDevice.
+type Option func(*ZehnderDevice)
+
+// HTTPConfig describes the HTTP server started with the device. Prefix is
+// passed to RegisterHandlers.
+type HTTPConfig struct {
+	Host   string
+	Port   int
+	Prefix string
+}
+
+// WithName sets the name of the device.
+func WithName(name string) Option {
+	return func(dev *ZehnderDevice) { dev.Name = name }
+}
+
+// WithTransport sets the transport used to reach the bus.
+func WithTransport(t Transport) Option {
+	return func(dev *ZehnderDevice) { dev.transport = t }
+}
+
+// WithLogger sets the logger used by the device.
+func WithLogger(logger *slog.Logger) Option {
+	return func(dev *ZehnderDevice) { dev.logger = logger }
+}
+
+// WithHeartbeatInterval sets how often the device announces itself on the
+// bus. The default is every 2 seconds.
+func WithHeartbeatInterval(interval time.Duration) Option {
+	return func(dev *ZehnderDevice) {
+		if interval > 0 {
+			dev.heartbeatInterval = interval
+		}
+	}
+}
+
+// WithCatalog sets the sensor catalog used to decode PDO values. The
+// catalog is updated as unknown PDOs are seen, so shouldn't be shared
+// between devices.
+func WithCatalog(catalog *SensorCatalog) Option {
+	return func(dev *ZehnderDevice) { dev.catalog = catalog }
+}
+
+// WithListenOnly stops the device from transmitting. No heartbeats or PDO
+// requests are sent and RMI requests fail, leaving the device to decode
+// the traffic it sees.
+func WithListenOnly() Option {
+	return func(dev *ZehnderDevice) { dev.listenOnly = true }
+}
+
+// WithHTTPServer starts an HTTP server for the device when it is started,
+// running until it is stopped.
+func WithHTTPServer(config HTTPConfig) Option {
+	return func(dev *ZehnderDevice) { dev.httpConfig = &config }
+}
+
+// WithStaleAfter sets how many intervals a requested PDO can be missing
+// for before its value is marked as stale. The default is 3.
+func WithStaleAfter(intervals int) Option {
+	return func(dev *ZehnderDevice) {
+		if intervals > 0 {
+			dev.store.staleAfter = intervals
+		}
+	}
+}
+
+// WithStaleRerequest makes the device request PDOs again when they go
+// stale, in case the unit has forgotten the request.
+func WithStaleRerequest() Option {
+	return func(dev *ZehnderDevice) { dev.rerequestStale = true }
+}
+
+// WithProfiles sets the profiles available to the device, replacing the
+// default profiles.
+func WithProfiles(profiles map[string]Profile) Option {
+	return func(dev *ZehnderDevice) { dev.profiles = profiles }
+}
+
+// WithProfile selects the profile to apply when the device starts.
+func WithProfile(name string) Option {
+	return func(dev *ZehnderDevice) { dev.profile = name }
+}
+
+// WithHistory sets how long the history of each PDO covers and the most
+// samples kept for each. The default is 24 hours of up to 43200 samples,
+// and a window of 0 disables the history.
+func WithHistory(window time.Duration, samples int) Option {
+	return func(dev *ZehnderDevice) {
+		if samples < 1 {
+			samples = defaultHistorySamples
+		}
+		dev.history = newHistory(window, samples)
+	}
+}
+
+// WithQueueSizes sets the sizes of the device's internal queues.
+func WithQueueSizes(sizes QueueSizes) Option {
+	return func(dev *ZehnderDevice) { dev.SetQueueSizes(sizes) }
+}
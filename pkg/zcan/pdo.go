@@ -1,11 +1,11 @@
 package zcan
 
 import (
-	"encoding/binary"
+	"context"
 	"encoding/hex"
 	"fmt"
-	"log"
 	"strings"
+	"time"
 
 	"go.einride.tech/can"
 )
@@ -16,31 +16,50 @@ func slugify(orig string) string {
 	return slug
 }
 
-func (dev *ZehnderDevice) processPDOFrame() {
-loop:
+func (dev *ZehnderDevice) processPDOFrame(ctx context.Context) error {
 	for {
 		select {
 		case frame := <-dev.pdoQ:
-			msg := pdoFromFrame(frame)
-			if msg.pdoId == 0 {
-				log.Println("Ignoring PDO with an ID of 0")
-				continue
+			dev.handlePDOFrame(frame)
+		case done := <-dev.pdoFlushQ:
+			for n := len(dev.pdoQ); n > 0; n-- {
+				dev.handlePDOFrame(<-dev.pdoQ)
 			}
-			// temp debugging for intervals
-			if msg.pdoId == 49 || msg.pdoId == 209 || msg.pdoId == 117 || msg.pdoId == 227 {
-				log.Println(frame)
+			select {
+			case dev.rmiFlushQ <- done:
+			case <-ctx.Done():
+				return nil
 			}
-			pv, ck := dev.pdoData[int(msg.pdoId)]
-			if !ck {
-				sensor := findSensor(int(msg.pdoId), msg.length)
-				pv = &PDOValue{sensor, nil}
-				dev.pdoData[int(msg.pdoId)] = pv
-			}
-			pv.Value = msg.data[:msg.length]
-		case <-dev.stopSignal:
-			break loop
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (dev *ZehnderDevice) handlePDOFrame(frame TimedFrame) {
+	if frame.IsRemote {
+		// PDO requests from other nodes on the bus
+		return
+	}
+	msg := pdoFromFrame(frame.Frame)
+	if msg.pdoId == 0 {
+		dev.logger.Debug("ignoring PDO with an ID of 0", "frame", frame.Frame)
+		return
+	}
+	dev.logger.Debug("PDO received", "pdo", msg.pdoId, "node", msg.nodeId, "frame", frame.Frame)
+	pv, changed := dev.store.update(int(msg.pdoId), msg.data[:msg.length], frame.Timestamp, func() PDOSensor {
+		sensor, added := dev.catalog.findSensor(int(msg.pdoId), msg.length)
+		if added {
+			dev.logger.Info("unknown sensor", "pdo", msg.pdoId, "length", msg.length)
 		}
+		return sensor
+	})
+	if n, ck := pv.Data().Number(); ck {
+		dev.history.add(pv.ID, pv.Updated, n)
 	}
+	update := PDOUpdate{Node: byte(msg.nodeId), Value: pv, Changed: changed}
+	dev.publishPDO(update)
+	dev.publishEvent(Event{Type: EventPDO, Time: frame.Timestamp, Node: update.Node, PDO: update.Value.ID, Update: &update})
 }
 
 type pdoMessage struct {
@@ -59,30 +78,89 @@ func pdoFromFrame(frame can.Frame) pdoMessage {
 	}
 }
 
-func (dev *ZehnderDevice) RequestPDO(prod byte, pdo uint16, interval byte) {
-	canid := uint32(pdo&0x7ff)<<14 + uint32(0x40+prod)
+// pdoRequest identifies a PDO requested from a product. The requests made
+// are remembered so they can be sent again after reconnecting.
+type pdoRequest struct {
+	product byte
+	pdo     uint16
+}
+
+func (req pdoRequest) frame(interval byte) can.Frame {
+	canid := uint32(req.pdo&0x7ff)<<14 + uint32(0x40+req.product)
 	frame := can.Frame{ID: canid, IsExtended: true, IsRemote: true}
 	copy(frame.Data[:], []byte{interval})
 	frame.Length = 1
-	dev.txQ <- frame
+	return frame
 }
 
-func (dev *ZehnderDevice) RequestPDOBySlug(prod byte, pdoSlug string, interval byte) error {
-	var pdo uint16 = 0
-	for id, poss := range sensorData {
-		if poss.slug == strings.ToLower(pdoSlug) {
-			pdo = uint16(id)
-			break
+// pdoOnChange is the interval used to request PDOs that are sent when
+// they change rather than at a fixed interval.
+const pdoOnChange = 0xFF
+
+// requestInterval returns how often a PDO requested with the interval is
+// expected, or 0 if it isn't sent at a fixed interval.
+func requestInterval(interval byte) time.Duration {
+	if interval == 0 || interval == pdoOnChange {
+		return 0
+	}
+	return time.Duration(interval) * time.Second
+}
+
+// RequestPDO asks the product to send the PDO every interval seconds. An
+// interval of 0 cancels the request and 255 asks for it when it changes.
+func (dev *ZehnderDevice) RequestPDO(prod byte, pdo uint16, interval byte) {
+	req := pdoRequest{prod, pdo}
+	dev.pdoMu.Lock()
+	if interval == 0 {
+		delete(dev.pdoRequests, req)
+		delete(dev.pdoRequested, req)
+	} else {
+		dev.pdoRequests[req] = interval
+		dev.pdoRequested[req] = time.Now()
+	}
+	dev.pdoMu.Unlock()
+	dev.store.setInterval(int(pdo), requestInterval(interval))
+	dev.publishEvent(Event{Type: EventPDORequest, Node: prod, PDO: int(pdo), Interval: interval})
+	dev.sendFrame(req.frame(interval))
+}
+
+// requestStale sends the requests again for PDOs that have gone stale
+// or never arrived, at most once every stale period.
+func (dev *ZehnderDevice) requestStale(now time.Time) {
+	type staleRequest struct {
+		req      pdoRequest
+		interval byte
+	}
+	var stale []staleRequest
+	dev.pdoMu.Lock()
+	for req, interval := range dev.pdoRequests {
+		expected := requestInterval(interval)
+		if !dev.store.stale(expected, dev.pdoRequested[req], now) {
+			continue
+		}
+		if pv, ck := dev.store.Get(int(req.pdo)); ck && !pv.Stale {
+			continue
+		}
+		dev.pdoRequested[req] = now
+		stale = append(stale, staleRequest{req, interval})
+	}
+	dev.pdoMu.Unlock()
+
+	for _, s := range stale {
+		dev.logger.Info("requesting stale PDO", "pdo", s.req.pdo, "node", s.req.product)
+		dev.publishEvent(Event{Type: EventPDORequest, Time: now, Node: s.req.product, PDO: int(s.req.pdo), Interval: s.interval})
+		if !dev.sendFrame(s.req.frame(s.interval)) {
+			return
 		}
 	}
-	if pdo == 0 {
+}
+
+func (dev *ZehnderDevice) RequestPDOBySlug(prod byte, pdoSlug string, interval byte) error {
+	pdo, _, ck := dev.catalog.SensorBySlug(pdoSlug)
+	if !ck {
 		return fmt.Errorf("no matching PDO found for '%s'", pdoSlug)
 	}
-	canid := uint32(pdo&0x7ff)<<14 + uint32(0x40+prod)
-	frame := can.Frame{ID: canid, IsExtended: true, IsRemote: true}
-	copy(frame.Data[:], []byte{interval})
-	frame.Length = 1
-	dev.txQ <- frame
+	dev.RequestPDO(prod, uint16(pdo), interval)
 	return nil
 }
 
@@ -120,152 +198,130 @@ const (
 	CN_VERSION
 )
 
+func (typ ZehnderType) String() string {
+	switch typ {
+	case CN_BOOL:
+		return "bool"
+	case CN_UINT8:
+		return "uint8"
+	case CN_UINT16:
+		return "uint16"
+	case CN_UINT32:
+		return "uint32"
+	case CN_INT8:
+		return "int8"
+	case CN_INT16:
+		return "int16"
+	case CN_INT64:
+		return "int64"
+	case CN_STRING:
+		return "string"
+	case CN_TIME:
+		return "time"
+	case CN_VERSION:
+		return "version"
+	}
+	return fmt.Sprintf("ZehnderType(%d)", int(typ))
+}
+
+func (typ ZehnderType) MarshalText() ([]byte, error) {
+	return []byte(typ.String()), nil
+}
+
+func (typ *ZehnderType) UnmarshalText(text []byte) error {
+	for t := CN_BOOL; t <= CN_VERSION; t++ {
+		if t.String() == strings.ToLower(string(text)) {
+			*typ = t
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown data type '%s'", text)
+}
+
+// size returns the number of bytes used to encode a value of the type.
+func (typ ZehnderType) size() int {
+	switch typ {
+	case CN_UINT16, CN_INT16:
+		return 2
+	case CN_UINT32, CN_TIME, CN_VERSION:
+		return 4
+	case CN_INT64:
+		return 8
+	}
+	return 1
+}
+
 type PDOSensor struct {
 	Name          string
 	slug          string
 	Units         string
 	DataType      ZehnderType
 	DecimalPlaces int
+	// Scale and Offset convert the raw value, e.g. a Scale of 0.1 for a
+	// value sent in tenths.
+	Scale  float64
+	Offset float64
+	// Enum gives the meanings of the values of sensors reporting a state.
+	Enum        map[int64]string
+	Description string
 }
 
 type PDOValue struct {
-	Sensor PDOSensor
-	Value  []byte
+	ID      int
+	Sensor  PDOSensor
+	Value   []byte
+	Updated time.Time
+	// Interval is how often the PDO was requested, or 0 if it isn't
+	// expected at a fixed interval. Stale is set once it has been missing
+	// for too long.
+	Interval time.Duration
+	Stale    bool
 }
 
-var sensorData = map[int]PDOSensor{
-	49:  {"Operating Mode", "operating_mode", UNIT_UNKNOWN, CN_INT8, 0},
-	65:  {"Fan Speed Setting", "fan_speed_setting", UNIT_UNKNOWN, CN_INT8, 0},
-	81:  {"Boost Period Remaining", "boost_period_remaining", UNIT_SECONDS, CN_UINT32, 0},
-	117: {"Exhaust Fan Duty", "exhaust_fan_duty", UNIT_PERCENT, CN_UINT8, 0},
-	118: {"Supply Fan Duty", "supply_fan_duty", UNIT_PERCENT, CN_UINT8, 0},
-	119: {"Exhaust Fan Flow", "exhaust_fan_flow", UNIT_M3H, CN_UINT16, 0},
-	120: {"Supply Fan Flow", "supply_fan_flow", UNIT_M3H, CN_UINT16, 0},
-	121: {"Exhaust Fan Speed", "exhaust_fan_speed", UNIT_RPM, CN_UINT16, 0},
-	122: {"Supply Fan Speed", "supply_fan_speed", UNIT_RPM, CN_UINT16, 0},
-	128: {"Power Consumption", "power_consumption", UNIT_WATT, CN_UINT16, 0},
-	130: {"Power Consumption Total", "power_consumption_total", UNIT_KWH, CN_UINT16, 0},
-	145: {"Preheater Power Consumption Total", "prehater_power_consumption_total", UNIT_KWH, CN_UINT16, 0},
-	146: {"Preheater Power Consumption", "preheater_power_consumption", UNIT_WATT, CN_UINT16, 0},
-	192: {"Filter Replacement Days", "filter_replacement_days", UNIT_DAYS, CN_UINT16, 0},
-	209: {"RMOT", "rmot", UNIT_CELCIUS, CN_UINT16, 1},
-	213: {"Avoided Heating Actual", "avoided_heating_actual", UNIT_WATT, CN_UINT16, 2},
-	214: {"Avoided Heating YTD", "avoided_heating_ytd", UNIT_KWH, CN_UINT16, 0},
-	220: {"Preheated Air Temperature (pre Heating)", "preheated_air_temperature_(pre_heating)", UNIT_CELCIUS, CN_UINT16, 1},
-	221: {"Preheated Air Temperature (post Heating)", "preheated_air_temperature_(post_heating)", UNIT_CELCIUS, CN_UINT16, 1},
-	227: {"Bypass State", "bypass_state", UNIT_PERCENT, CN_UINT8, 0},
-	274: {"Extract Air Temperature", "extract_air_temperature", UNIT_CELCIUS, CN_UINT16, 1},
-	275: {"Exhaust Air Temperature", "exhaust_air_temperature", UNIT_CELCIUS, CN_UINT16, 1},
-	276: {"Outdoor Air Temperature", "outdoor_air_temperature", UNIT_CELCIUS, CN_UINT16, 1},
-	277: {"Preheated Outside Air Temperature", "preheated_outside_air_temperature", UNIT_CELCIUS, CN_UINT16, 1},
-	278: {"Supply Air Temperature", "supply_air_temperature", UNIT_CELCIUS, CN_UINT16, 1},
-	290: {"Extract Humidity", "extract_humidity", UNIT_PERCENT, CN_UINT8, 0},
-	291: {"Exhaust Humidity", "exhaust_humidity", UNIT_PERCENT, CN_UINT8, 0},
-	292: {"Outdoor Humidity", "outdoor_humidity", UNIT_PERCENT, CN_UINT8, 0},
-	293: {"Preheated Outdoor Humidity", "preheated_outdoor_humidity", UNIT_PERCENT, CN_UINT8, 0},
-	294: {"Supply Air Humidity", "supply_air_humidity", UNIT_PERCENT, CN_INT8, 0},
-}
-
-func findSensor(pdo int, dataLen int) PDOSensor {
-	sensor, ck := sensorData[pdo]
-	if !ck {
-		log.Printf("unknown sensor 0x%02x [%d] %d bytes of data", pdo, pdo, dataLen)
-		sensorName := fmt.Sprintf("Unknown sensor %d", pdo)
-		sensor = PDOSensor{sensorName, slugify(sensorName), UNIT_UNKNOWN, CN_UINT16, 0}
-		if dataLen == 1 {
-			sensor.DataType = CN_UINT8
-		} else if dataLen == 4 {
-			sensor.DataType = CN_UINT32
-		}
-		sensorData[pdo] = sensor
-	}
-	return sensor
+// Data returns the decoded value.
+func (pv PDOValue) Data() Value {
+	return pv.Sensor.Decode(pv.Value)
 }
 
+// GetData returns the decoded value as a Go value, see Value.Interface.
 func (pv PDOValue) GetData() interface{} {
-	switch pv.Sensor.DataType {
-	case CN_BOOL:
-		return pv.Value[0] == 1
-	case CN_STRING:
-		rb := 0
-		var c byte
-		for rb, c = range pv.Value {
-			if c == 0 {
-				break
-			}
-		}
-		return string(pv.Value[:rb+1])
-	case CN_VERSION:
-		return ZehnderVersionDecode(binary.LittleEndian.Uint32(pv.Value))
-	case CN_UINT8, CN_UINT16, CN_UINT32:
-		val := pv.Number()
-		if pv.Sensor.DecimalPlaces > 0 {
-			return float64(val) / (float64(pv.Sensor.DecimalPlaces) * 10)
-		}
-		return val
-	case CN_INT8, CN_INT16, CN_INT64:
-		val := pv.SignedNumber()
-		if pv.Sensor.DecimalPlaces > 0 {
-			return float64(val) / (float64(pv.Sensor.DecimalPlaces) * 10)
-		}
-		return val
-	}
-	return "Unknown"
+	return pv.Data().Interface()
 }
 
 func (pv PDOValue) String() string {
 	s := fmt.Sprintf("%-45s0x%-8s", pv.Sensor.Name, strings.ToUpper(hex.EncodeToString(pv.Value)))
-	if pv.IsFloat() {
-		fmtS := fmt.Sprintf("  %%6.%df", pv.Sensor.DecimalPlaces)
-		s += fmt.Sprintf(fmtS, pv.Float())
-	} else if pv.IsSigned() {
-		s += fmt.Sprintf("  %6d", pv.SignedNumber())
-	} else {
-		s += fmt.Sprintf("  %6d", pv.Number())
-	}
+	s += fmt.Sprintf("  %6s", pv.Data())
 	s += " " + pv.Sensor.Units
 	return s
 }
 
 func (pv PDOValue) IsBool() bool   { return pv.Sensor.DataType == CN_BOOL }
 func (pv PDOValue) IsString() bool { return pv.Sensor.DataType == CN_STRING }
-func (pv PDOValue) IsFloat() bool  { return pv.Sensor.DecimalPlaces > 0 }
+func (pv PDOValue) IsFloat() bool  { return pv.Data().Kind == ValueFloat }
 func (pv PDOValue) IsSigned() bool {
 	return pv.Sensor.DataType == CN_INT8 || pv.Sensor.DataType == CN_INT16 || pv.Sensor.DataType == CN_INT64
 }
 
+// Number returns the raw value of unsigned sensors.
 func (pv PDOValue) Number() uint {
-	if pv.Sensor.DataType == CN_INT16 || pv.Sensor.DataType == CN_INT8 || pv.Sensor.DataType == CN_INT64 {
-		log.Println("attempt to get an unsigned number from a sensor with a signed data type?")
+	if pv.IsSigned() {
 		return 0
 	}
-	switch pv.Sensor.DataType {
-	case CN_UINT8:
-		return uint(pv.Value[0])
-	case CN_UINT16:
-		return uint(binary.LittleEndian.Uint16(pv.Value))
-	case CN_UINT32:
-		return uint(binary.LittleEndian.Uint32(pv.Value))
-	}
-	return 0
+	raw, _ := decodeInt(pv.Sensor.DataType, pv.Value)
+	return uint(raw)
 }
 
+// SignedNumber returns the raw value of signed sensors.
 func (pv PDOValue) SignedNumber() int {
-	if pv.Sensor.DataType == CN_UINT16 || pv.Sensor.DataType == CN_UINT8 || pv.Sensor.DataType == CN_UINT32 {
-		log.Println("attempt to get an signed number from a sensor with an unsigned data type?")
+	if !pv.IsSigned() {
 		return 0
 	}
-	switch pv.Sensor.DataType {
-	case CN_INT8:
-		return int(pv.Value[0])
-	case CN_INT16:
-		return int(binary.LittleEndian.Uint16(pv.Value))
-	case CN_INT64:
-		return int(binary.LittleEndian.Uint32(pv.Value))
-	}
-	return 0
+	raw, _ := decodeInt(pv.Sensor.DataType, pv.Value)
+	return int(raw)
 }
 
+// Float returns the value with the sensor's scale and offset applied.
 func (pv PDOValue) Float() float64 {
-	return float64(pv.Number()) / (float64(pv.Sensor.DecimalPlaces) * 10)
+	raw, _ := decodeInt(pv.Sensor.DataType, pv.Value)
+	return pv.Sensor.scaled(raw)
 }
@@ -0,0 +1,151 @@
+package zcan
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// profilesVersion is the version of the profiles file format.
+const profilesVersion = 1
+
+// defaultProfilesData holds the built in profiles.
+//
+//go:embed profiles.json
+var defaultProfilesData []byte
+
+// Profile is a set of sensors to request from a node, given by slug along
+// with the interval in seconds to request each at. Nodes default to 1.
+type Profile struct {
+	Node    byte            `json:"node"`
+	Sensors map[string]byte `json:"sensors"`
+}
+
+type profilesFile struct {
+	Version  int                `json:"version"`
+	Profiles map[string]Profile `json:"profiles"`
+}
+
+func parseProfiles(r io.Reader) (map[string]Profile, error) {
+	var file profilesFile
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&file); err != nil {
+		return nil, fmt.Errorf("invalid profiles: %w", err)
+	}
+	if file.Version != profilesVersion {
+		return nil, fmt.Errorf("unsupported profiles version %d, expected %d", file.Version, profilesVersion)
+	}
+	for name, profile := range file.Profiles {
+		if profile.Node == 0 {
+			profile.Node = 1
+			file.Profiles[name] = profile
+		}
+	}
+	return file.Profiles, nil
+}
+
+// DefaultProfiles returns the built in profiles: minimal, energy and full.
+func DefaultProfiles() map[string]Profile {
+	profiles, err := parseProfiles(bytes.NewReader(defaultProfilesData))
+	if err != nil {
+		panic(err)
+	}
+	return profiles
+}
+
+// LoadProfiles returns the default profiles, extended and overridden by
+// those in the profiles file given.
+func LoadProfiles(filename string) (map[string]Profile, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	extra, err := parseProfiles(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	profiles := DefaultProfiles()
+	for name, profile := range extra {
+		profiles[name] = profile
+	}
+	return profiles, nil
+}
+
+// profileRequests returns the PDO requests for the named profile, or none
+// for an empty name.
+func (dev *ZehnderDevice) profileRequests(name string) (map[pdoRequest]byte, error) {
+	if name == "" {
+		return nil, nil
+	}
+	profile, ck := dev.profiles[name]
+	if !ck {
+		return nil, fmt.Errorf("unknown profile '%s'", name)
+	}
+	requests := make(map[pdoRequest]byte, len(profile.Sensors))
+	for slug, interval := range profile.Sensors {
+		pdo, _, ck := dev.catalog.SensorBySlug(slug)
+		if !ck {
+			return nil, fmt.Errorf("profile '%s': no matching PDO found for '%s'", name, slug)
+		}
+		requests[pdoRequest{profile.Node, uint16(pdo)}] = interval
+	}
+	return requests, nil
+}
+
+// applyProfile cancels the previous requests that aren't wanted and makes
+// the new ones. It's called with profileMu held.
+func (dev *ZehnderDevice) applyProfile(previous, requests map[pdoRequest]byte) {
+	for req := range previous {
+		if _, ck := requests[req]; !ck {
+			dev.RequestPDO(req.product, req.pdo, 0)
+		}
+	}
+	for req, interval := range requests {
+		dev.RequestPDO(req.product, req.pdo, interval)
+	}
+}
+
+// SetProfile changes the profile used by the device, requesting its
+// sensors and cancelling those of the previous profile that it doesn't
+// include. An empty name removes the profile. Before the device is started
+// the profile is only selected, and is applied by Start.
+func (dev *ZehnderDevice) SetProfile(name string) error {
+	dev.profileMu.Lock()
+	defer dev.profileMu.Unlock()
+	requests, err := dev.profileRequests(name)
+	if err != nil {
+		return err
+	}
+	if dev.running() && dev.hasNetwork() {
+		dev.applyProfile(dev.activeProfile, requests)
+		dev.activeProfile = requests
+	}
+	dev.profile = name
+	dev.logger.Info("profile selected", "profile", name)
+	return nil
+}
+
+// Profile returns the name of the current profile.
+func (dev *ZehnderDevice) Profile() string {
+	dev.profileMu.Lock()
+	defer dev.profileMu.Unlock()
+	return dev.profile
+}
+
+// Profiles returns the names of the profiles available.
+func (dev *ZehnderDevice) Profiles() []string {
+	dev.profileMu.Lock()
+	defer dev.profileMu.Unlock()
+	names := make([]string, 0, len(dev.profiles))
+	for name := range dev.profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,132 @@
+package zcan
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.einride.tech/can"
+)
+
+var testProfiles = map[string]Profile{
+	"flow":  {Node: 1, Sensors: map[string]byte{"supply_fan_flow": pdoOnChange, "exhaust_fan_speed": 10}},
+	"speed": {Node: 1, Sensors: map[string]byte{"supply_fan_flow": pdoOnChange, "supply_fan_speed": 30}},
+}
+
+// collectPDORequests returns the intervals of the next n PDO requests,
+// by PDO.
+func collectPDORequests(t *testing.T, requests <-chan can.Frame, n int) map[uint16]byte {
+	t.Helper()
+	rv := make(map[uint16]byte, n)
+	for len(rv) < n {
+		select {
+		case frame := <-requests:
+			rv[uint16(frame.ID>>14)] = frame.Data[0]
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d of %d PDO requests seen: %v", len(rv), n, rv)
+		}
+	}
+	return rv
+}
+
+func checkPDORequests(t *testing.T, got, expected map[uint16]byte) {
+	t.Helper()
+	if len(got) != len(expected) {
+		t.Errorf("PDO requests were %v, expected %v", got, expected)
+		return
+	}
+	for pdo, interval := range expected {
+		if got[pdo] != interval {
+			t.Errorf("PDO requests were %v, expected %v", got, expected)
+			return
+		}
+	}
+}
+
+// startProfiled starts a device on the bus using the test profiles.
+func startProfiled(t *testing.T, bus *VirtualBus, opts ...Option) *ZehnderDevice {
+	t.Helper()
+	opts = append([]Option{WithTransport(bus.Attach(false)), WithLogger(testLogger), WithProfiles(testProfiles)}, opts...)
+	dev := NewZehnderDevice(55, opts...)
+	if err := dev.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(dev.Stop)
+	return dev
+}
+
+func TestSetProfile(t *testing.T) {
+	bus := NewVirtualBus()
+	requests := watchPDORequests(t, bus)
+	dev := startProfiled(t, bus, WithProfile("flow"))
+
+	checkPDORequests(t, collectPDORequests(t, requests, 2), map[uint16]byte{120: pdoOnChange, 121: 10})
+
+	// the exhaust fan speed is cancelled and the supply fan speed requested
+	if err := dev.SetProfile("speed"); err != nil {
+		t.Fatal(err)
+	}
+	checkPDORequests(t, collectPDORequests(t, requests, 3), map[uint16]byte{120: pdoOnChange, 121: 0, 122: 30})
+	if dev.Profile() != "speed" {
+		t.Errorf("profile is %q, expected speed", dev.Profile())
+	}
+
+	if err := dev.SetProfile("unknown"); err == nil {
+		t.Error("unknown profile selected")
+	}
+	expectNoPDORequest(t, requests)
+	if dev.Profile() != "speed" {
+		t.Errorf("profile is %q after an error, expected speed", dev.Profile())
+	}
+
+	if err := dev.SetProfile(""); err != nil {
+		t.Fatal(err)
+	}
+	checkPDORequests(t, collectPDORequests(t, requests, 2), map[uint16]byte{120: 0, 122: 0})
+	dev.pdoMu.Lock()
+	remaining := len(dev.pdoRequests)
+	dev.pdoMu.Unlock()
+	if remaining != 0 {
+		t.Errorf("%d PDO requests remain without a profile", remaining)
+	}
+}
+
+func TestProfileNodeReappears(t *testing.T) {
+	lostTimeout := nodeLostTimeout
+	nodeLostTimeout = 200 * time.Millisecond
+	t.Cleanup(func() { nodeLostTimeout = lostTimeout })
+
+	bus := NewVirtualBus()
+	node := bus.Attach(false)
+	if err := node.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer node.Close()
+	requests := watchPDORequests(t, bus)
+	dev := startProfiled(t, bus, WithProfile("flow"), WithHeartbeatInterval(50*time.Millisecond))
+	events := dev.SubscribeEvents(EventNodeSeen, EventNodeLost)
+	defer events.Close()
+	expectEvent := func(typ EventType) {
+		t.Helper()
+		select {
+		case ev := <-events.C:
+			if ev.Type != typ || ev.Node != 1 {
+				t.Fatalf("unexpected event %+v", ev)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no %s event for node 1", typ)
+		}
+	}
+	heartbeat := can.Frame{ID: 0x10000001, IsExtended: true}
+
+	checkPDORequests(t, collectPDORequests(t, requests, 2), map[uint16]byte{120: pdoOnChange, 121: 10})
+	node.Transmit(context.Background(), heartbeat)
+	expectEvent(EventNodeSeen)
+	expectNoPDORequest(t, requests)
+
+	expectEvent(EventNodeLost)
+	node.Transmit(context.Background(), heartbeat)
+	expectEvent(EventNodeSeen)
+	// the node has probably restarted, so the profile is requested again
+	checkPDORequests(t, collectPDORequests(t, requests, 2), map[uint16]byte{120: pdoOnChange, 121: 10})
+}
@@ -0,0 +1,83 @@
+package zcan
+
+// QueueSizes sets the capacity of the device's internal queues. Received
+// frames pass through the frame queue before being sorted onto the PDO, RMI
+// and heartbeat queues, while the transmit queue holds frames waiting to be
+// sent.
+//
+// When the PDO or heartbeat queue is full the oldest frame is dropped to
+// make room, as a newer value will follow. RMI frames are never dropped, so
+// a full RMI queue holds up the frame queue and, once that is full, the
+// receiver. A full transmit queue holds up the caller.
+type QueueSizes struct {
+	Frame     int
+	PDO       int
+	RMI       int
+	Heartbeat int
+	Transmit  int
+}
+
+// DefaultQueueSizes are the queue sizes used unless changed by
+// WithQueueSizes or SetQueueSizes.
+var DefaultQueueSizes = QueueSizes{
+	Frame:     256,
+	PDO:       256,
+	RMI:       64,
+	Heartbeat: 16,
+	Transmit:  64,
+}
+
+// QueueStats describes one of the device's internal queues.
+type QueueStats struct {
+	Size    int    `json:"size"`
+	Depth   int    `json:"depth"`
+	Dropped uint64 `json:"dropped"`
+}
+
+// SetQueueSizes changes the sizes of the internal queues. It must be called
+// before Start and any size less than 1 is left at its default.
+func (dev *ZehnderDevice) SetQueueSizes(sizes QueueSizes) {
+	pick := func(size, def int) int {
+		if size < 1 {
+			return def
+		}
+		return size
+	}
+	dev.queueSizes = QueueSizes{
+		Frame:     pick(sizes.Frame, DefaultQueueSizes.Frame),
+		PDO:       pick(sizes.PDO, DefaultQueueSizes.PDO),
+		RMI:       pick(sizes.RMI, DefaultQueueSizes.RMI),
+		Heartbeat: pick(sizes.Heartbeat, DefaultQueueSizes.Heartbeat),
+		Transmit:  pick(sizes.Transmit, DefaultQueueSizes.Transmit),
+	}
+}
+
+// pushDropOldest adds a frame to the queue, discarding the oldest frames
+// queued until there is room. It returns the number of frames dropped and
+// must only be used by the queue's sole sender.
+func pushDropOldest(q chan TimedFrame, frame TimedFrame) uint64 {
+	var dropped uint64
+	for {
+		select {
+		case q <- frame:
+			return dropped
+		default:
+		}
+		select {
+		case <-q:
+			dropped++
+		default:
+		}
+	}
+}
+
+func (dev *ZehnderDevice) queueStats() map[string]QueueStats {
+	drops := dev.stats.drops()
+	return map[string]QueueStats{
+		"frame":     {Size: cap(dev.frameQ), Depth: len(dev.frameQ), Dropped: drops["frame"]},
+		"pdo":       {Size: cap(dev.pdoQ), Depth: len(dev.pdoQ), Dropped: drops["pdo"]},
+		"rmi":       {Size: cap(dev.rmiQ), Depth: len(dev.rmiQ), Dropped: drops["rmi"]},
+		"heartbeat": {Size: cap(dev.heartbeatQ), Depth: len(dev.heartbeatQ), Dropped: drops["heartbeat"]},
+		"transmit":  {Size: cap(dev.txQ), Depth: len(dev.txQ), Dropped: drops["transmit"]},
+	}
+}
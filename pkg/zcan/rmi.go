@@ -1,62 +1,106 @@
 package zcan
 
 import (
-	"encoding/binary"
+	"bytes"
+	"context"
 	"fmt"
-	"log"
+	"time"
 
 	"go.einride.tech/can"
 )
 
-func (dev *ZehnderDevice) processRMIFrame() {
+func (dev *ZehnderDevice) processRMIFrame(ctx context.Context) error {
 	var holder *ZehnderRMI
-	dev.wg.Add(1)
-	defer dev.wg.Done()
 
-loop:
 	for {
 		select {
 		case frame := <-dev.rmiQ:
-			rmi := rmiFromFrame(frame)
-			if rmi.DestId != dev.NodeID {
-				if rmi.SourceId == dev.NodeID {
-					continue
-				}
-				log.Printf("Received RMI but it's not for us...%02X vs wanted %02X\n", rmi.DestId, dev.NodeID)
-				log.Printf("FRAME: %v\n", rmi)
-				continue
+			holder = dev.handleRMIFrame(frame, holder)
+		case done := <-dev.rmiFlushQ:
+			for n := len(dev.rmiQ); n > 0; n-- {
+				holder = dev.handleRMIFrame(<-dev.rmiQ, holder)
 			}
-			if rmi.IsMulti {
-				if holder != nil {
-					holder.appendRMI(rmi)
-				} else {
-					holder = rmi
-				}
-				if holder.finalSeen {
-					dev.doRMICallback(holder)
-					holder = nil
-				}
-			} else {
-				dev.doRMICallback(rmi)
-			}
-		case <-dev.stopSignal:
-			break loop
+			close(done)
+		case <-ctx.Done():
+			return nil
 		}
 	}
 }
 
+// handleRMIFrame decodes an RMI frame, collecting the frames of a multi-frame
+// message in holder until the final one is seen. It returns the new holder.
+func (dev *ZehnderDevice) handleRMIFrame(frame TimedFrame, holder *ZehnderRMI) *ZehnderRMI {
+	rmi, err := rmiFromFrame(frame.Frame)
+	if err != nil {
+		dev.reportError(ErrorDecode, "rmi", err)
+		return holder
+	}
+	rmi.Timestamp = frame.Timestamp
+	if rmi.DestId != dev.NodeID {
+		if rmi.SourceId != dev.NodeID {
+			dev.logger.Debug("ignoring RMI for another node", "dest", rmi.DestId, "source", rmi.SourceId)
+		}
+		return holder
+	}
+	if !rmi.IsMulti {
+		dev.doRMICallback(rmi)
+		return holder
+	}
+	if holder != nil {
+		holder.appendRMI(rmi)
+	} else {
+		holder = rmi
+	}
+	if holder.finalSeen {
+		dev.doRMICallback(holder)
+		return nil
+	}
+	return holder
+}
+
 func (dev *ZehnderDevice) doRMICallback(rmi *ZehnderRMI) {
-	if dev.rmiCbFn != nil {
-		dev.rmiCbFn(rmi)
-		dev.rmiCbFn = nil
+	ev := Event{Type: EventRMIResponse, Time: rmi.Timestamp, Node: rmi.SourceId, RMI: rmi.copy()}
+	if rmi.IsError {
+		ev.Type = EventRMIError
+	}
+	dev.publishEvent(ev)
+	if rmi.IsError {
+		code := -1
+		if rmi.DataLength > 0 {
+			code = int(rmi.Data[0])
+		}
+		dev.reportError(ErrorProtocol, "rmi", fmt.Errorf("node %d returned error %d", rmi.SourceId, code))
+	}
+
+	dev.rmiMu.Lock()
+	cbFn := dev.rmiCbFn
+	dev.rmiCbFn = nil
+	dev.rmiMu.Unlock()
+	if cbFn != nil {
+		cbFn(rmi)
 	} else if dev.defaultRMICbFn != nil {
 		dev.defaultRMICbFn(rmi)
 	} else {
-		log.Println("RMI message received, but no callback was set?")
+		dev.logger.Warn("RMI message received, but no callback was set")
 	}
 
-	if dev.hasNetwork() {
-		dev.rmiCTS <- true
+	// Let the queue send the next request, unless it isn't waiting.
+	select {
+	case dev.rmiCTS <- true:
+	default:
+	}
+}
+
+// queueRMI adds a request to the queue of RMI requests to be sent, unless
+// the device has stopped or is listen-only.
+func (dev *ZehnderDevice) queueRMI(rmi *ZehnderRMI) {
+	if dev.listenOnly {
+		dev.reportError(ErrorProtocol, "rmi", fmt.Errorf("cannot send request to node %d when listen-only", rmi.DestId))
+		return
+	}
+	select {
+	case dev.rmiRequestQ <- rmi:
+	case <-dev.quit:
 	}
 }
 
@@ -79,6 +123,8 @@ type ZehnderRMI struct {
 	IsError    bool
 	Data       []byte
 	DataLength int
+	// Timestamp is when the last frame of a received RMI arrived.
+	Timestamp time.Time
 
 	msgNo      byte
 	finalSeen  bool
@@ -96,17 +142,21 @@ func NewZehnderDestination(node byte, unit byte, subunit byte) ZehnderDestinatio
 	return ZehnderDestination{node, unit, subunit}
 }
 
+// nextRMISequence returns the 2 bit sequence number for a new request.
+func (dev *ZehnderDevice) nextRMISequence() byte {
+	return byte(dev.rmiSequence.Add(1)-1) & 0x03
+}
+
 func (zr ZehnderDestination) GetOne(dev *ZehnderDevice, prop byte, flags ZehnderTypeFlag, cbFn func(*ZehnderRMI)) {
-	rmi := ZehnderRMI{SourceId: dev.NodeID, DestId: zr.DestNodeId, IsRequest: true, Sequence: dev.rmiSequence}
+	rmi := ZehnderRMI{SourceId: dev.NodeID, DestId: zr.DestNodeId, IsRequest: true, Sequence: dev.nextRMISequence()}
 	rmi.Data = []byte{0x01, zr.Unit, zr.SubUnit, byte(flags), prop}
 	rmi.DataLength = 5
 	rmi.callbackFn = cbFn
-	dev.rmiSequence = (dev.rmiSequence + 1) & 0x03
-	dev.rmiRequestQ <- &rmi
+	dev.queueRMI(&rmi)
 }
 
 func (zr ZehnderDestination) GetMultiple(dev *ZehnderDevice, props []byte, flags ZehnderTypeFlag, cbFn func(*ZehnderRMI)) {
-	rmi := ZehnderRMI{SourceId: dev.NodeID, DestId: zr.DestNodeId, IsRequest: true, Sequence: dev.rmiSequence}
+	rmi := ZehnderRMI{SourceId: dev.NodeID, DestId: zr.DestNodeId, IsRequest: true, Sequence: dev.nextRMISequence()}
 	or_type := byte(flags) | byte(len(props))
 	rmi.Data = append([]byte{0x02, zr.Unit, zr.SubUnit, 1, or_type}, props...)
 	rmi.DataLength = len(rmi.Data)
@@ -114,24 +164,30 @@ func (zr ZehnderDestination) GetMultiple(dev *ZehnderDevice, props []byte, flags
 		rmi.IsMulti = true
 	}
 	rmi.callbackFn = cbFn
-	dev.rmiSequence = (dev.rmiSequence + 1) & 0x03
-	dev.rmiRequestQ <- &rmi
+	dev.queueRMI(&rmi)
 }
 
 func (zr ZehnderDestination) SetOne(dev *ZehnderDevice, prop byte, value []byte) {
-	// Untested
-	rmi := ZehnderRMI{SourceId: dev.NodeID, DestId: zr.DestNodeId, IsRequest: true, Sequence: dev.rmiSequence}
+	rmi := ZehnderRMI{SourceId: dev.NodeID, DestId: zr.DestNodeId, IsRequest: true, Sequence: dev.nextRMISequence()}
 	rmi.Data = append([]byte{0x03, zr.Unit, zr.SubUnit, prop}, value...)
-	dev.rmiSequence = (dev.rmiSequence + 1) & 0x03
-	dev.rmiRequestQ <- &rmi
+	rmi.DataLength = len(rmi.Data)
+	if rmi.DataLength > 8 {
+		rmi.IsMulti = true
+	}
+	dev.queueRMI(&rmi)
 }
 
-func rmiFromFrame(frame can.Frame) *ZehnderRMI {
+// rmiFromFrame decodes a frame of an RMI message. Frames of multi-frame
+// messages must carry the message number.
+func rmiFromFrame(frame can.Frame) (*ZehnderRMI, error) {
 	rmi := ZehnderRMI{SourceId: byte(frame.ID & 0x3F)}
 	rmi.DestId = byte(frame.ID>>6) & 0x3F
 	rmi.Counter = byte(frame.ID>>12) & 0x03
 	rmi.Sequence = byte(frame.ID>>17) & 0x03
 	rmi.IsMulti = frame.ID&(1<<14) == 1<<14
+	if rmi.IsMulti && frame.Length == 0 {
+		return nil, fmt.Errorf("multi-frame RMI from node %d has no data", rmi.SourceId)
+	}
 	rmi.IsError = frame.ID&(1<<15) == 1<<15
 	rmi.IsRequest = frame.ID&(1<<16) == 1<<16
 	rmi.Data = frame.Data[:frame.Length]
@@ -141,18 +197,29 @@ func rmiFromFrame(frame can.Frame) *ZehnderRMI {
 		rmi.finalSeen = true
 	} else {
 		rmi.msgNo = rmi.Data[0]
+		if rmi.msgNo&0x80 == 0x80 {
+			rmi.finalSeen = true
+			rmi.msgNo &= 0x7F
+		}
 		rmi.DataLength -= 1
 		rmi.Data = rmi.Data[1:]
 	}
-	return &rmi
+	return &rmi, nil
+}
+
+// copy returns a copy of the RMI for use in events, so that reading its
+// data doesn't affect the original.
+func (zrmi *ZehnderRMI) copy() *ZehnderRMI {
+	rv := *zrmi
+	rv.readPos = 0
+	rv.callbackFn = nil
+	return &rv
 }
 
 func (zrmi *ZehnderRMI) appendRMI(xtra *ZehnderRMI) {
 	zrmi.msgNo = xtra.msgNo
-	if zrmi.msgNo&0x80 == 0x80 {
-		zrmi.finalSeen = true
-		zrmi.msgNo &= 0x7F
-	}
+	zrmi.finalSeen = xtra.finalSeen
+	zrmi.Timestamp = xtra.Timestamp
 	zrmi.Data = append(zrmi.Data, xtra.Data...)
 	zrmi.DataLength += xtra.DataLength
 }
@@ -174,12 +241,44 @@ func (zrmi ZehnderRMI) MakeCANId() uint32 {
 	return can_id
 }
 
+// frames splits the RMI into the CAN frames needed to transmit it. Multi
+// frame messages carry 7 bytes per frame, prefixed by the message number
+// with the top bit set on the final frame.
+func (zrmi *ZehnderRMI) frames() []can.Frame {
+	id := zrmi.MakeCANId()
+	data := zrmi.Data[:zrmi.DataLength]
+	if !zrmi.IsMulti {
+		frame := can.Frame{ID: id, IsExtended: true, Length: uint8(len(data))}
+		copy(frame.Data[:], data)
+		return []can.Frame{frame}
+	}
+	var frames []can.Frame
+	for n := 0; n*7 < len(data); n++ {
+		chunk := data[n*7:]
+		msgNo := byte(n)
+		if len(chunk) <= 7 {
+			msgNo |= 0x80
+		} else {
+			chunk = chunk[:7]
+		}
+		frame := can.Frame{ID: id, IsExtended: true, Length: uint8(len(chunk) + 1)}
+		frame.Data[0] = msgNo
+		copy(frame.Data[1:], chunk)
+		frames = append(frames, frame)
+	}
+	return frames
+}
+
 func (zrmi *ZehnderRMI) send(dev *ZehnderDevice) error {
+	dev.rmiMu.Lock()
 	dev.rmiCbFn = zrmi.callbackFn
-	frame := can.Frame{ID: zrmi.MakeCANId(), IsExtended: true}
-	copy(frame.Data[:], zrmi.Data[:])
-	frame.Length = uint8(zrmi.DataLength)
-	dev.txQ <- frame
+	dev.rmiMu.Unlock()
+	for _, frame := range zrmi.frames() {
+		if !dev.sendFrame(frame) {
+			return fmt.Errorf("device stopped")
+		}
+	}
+	dev.publishEvent(Event{Type: EventRMIRequest, Node: zrmi.DestId, RMI: zrmi.copy()})
 	return nil
 }
 
@@ -188,62 +287,67 @@ func (zrmi *ZehnderRMI) GetData(typ ZehnderType) (rv any, err error) {
 		err = fmt.Errorf("unable to extract any more data from the RMI data")
 		return
 	}
-	data := zrmi.Data[zrmi.readPos:]
-	switch typ {
-	case CN_BOOL:
-		rv = data[0] == 1
-		zrmi.readPos++
-	case CN_STRING:
-		rb := 0
-		var c byte
-		for rb, c = range data[:zrmi.DataLength] {
-			if c == 0 {
-				break
-			}
+	data := zrmi.Data[zrmi.readPos:zrmi.DataLength]
+	if typ == CN_STRING {
+		rb := bytes.IndexByte(data, 0)
+		if rb == -1 {
+			rb = len(data)
 		}
 		rv = string(data[:rb])
 		zrmi.readPos += rb + 1
+		return
+	}
+	var val int64
+	if val, err = decodeInt(typ, data); err != nil {
+		return
+	}
+	switch typ {
+	case CN_BOOL:
+		rv = val == 1
 	case CN_VERSION:
-		vers := ZehnderVersionDecode(binary.LittleEndian.Uint32(data))
+		vers := ZehnderVersionDecode(uint32(val))
 		rv = fmt.Sprintf("%d.%d", vers[0], vers[1])
-		zrmi.readPos += 4
-	case CN_UINT8:
-		rv = uint(data[0])
-		zrmi.readPos++
-	case CN_UINT16:
-		rv = uint(binary.LittleEndian.Uint16(data))
-		zrmi.readPos += 2
-	case CN_UINT32:
-		rv = uint(binary.LittleEndian.Uint32(data))
-		zrmi.readPos += 4
-	case CN_INT8:
-		rv = int(data[0])
-		zrmi.readPos++
-	case CN_INT16:
-		rv = int(binary.LittleEndian.Uint16(data))
-		zrmi.readPos += 2
-	case CN_INT64:
-		rv = int(binary.LittleEndian.Uint32(data))
-		zrmi.readPos += 8
+	case CN_UINT8, CN_UINT16, CN_UINT32:
+		rv = uint(val)
+	case CN_INT8, CN_INT16, CN_INT64:
+		rv = int(val)
+	default:
+		err = fmt.Errorf("unable to extract %s from the RMI data", typ)
+		return
 	}
+	zrmi.readPos += typ.size()
 	return
 }
 
-func (dev *ZehnderDevice) processRMIQueue() {
-	dev.wg.Add(1)
-loop:
+// processRMIQueue sends the queued RMI requests one at a time, waiting for
+// the response to each before sending the next.
+func (dev *ZehnderDevice) processRMIQueue(ctx context.Context) error {
 	for {
 		select {
-		case <-dev.rmiCTS:
+		case rmi := <-dev.rmiRequestQ:
+			// discard any go-ahead left by an unsolicited response
+			select {
+			case <-dev.rmiCTS:
+			default:
+			}
+			if err := rmi.send(dev); err != nil {
+				return nil
+			}
+			timer := time.NewTimer(rmiResponseTimeout)
 			select {
-			case rmi := <-dev.rmiRequestQ:
-				rmi.send(dev)
-			case <-dev.stopSignal:
-				break loop
+			case <-dev.rmiCTS:
+			case <-timer.C:
+				dev.rmiMu.Lock()
+				dev.rmiCbFn = nil
+				dev.rmiMu.Unlock()
+				dev.reportError(ErrorTimeout, "rmi", fmt.Errorf("no response from node %d after %s", rmi.DestId, rmiResponseTimeout))
+			case <-ctx.Done():
+				timer.Stop()
+				return nil
 			}
-		case <-dev.stopSignal:
-			break loop
+			timer.Stop()
+		case <-ctx.Done():
+			return nil
 		}
 	}
-	dev.wg.Done()
 }
@@ -0,0 +1,50 @@
+package zcan
+
+import "testing"
+
+func TestRMIGetData(t *testing.T) {
+	for _, tc := range []struct {
+		typ      ZehnderType
+		data     []byte
+		expected any
+		invalid  bool
+	}{
+		{CN_BOOL, []byte{0x01}, true, false},
+		{CN_UINT8, []byte{0xFF}, uint(255), false},
+		{CN_UINT16, []byte{0x9F, 0x03}, uint(927), false},
+		{CN_UINT32, []byte{0x84, 0x03, 0x00, 0x00}, uint(900), false},
+		{CN_INT16, []byte{0xE9, 0xFF}, -23, false},
+		{CN_VERSION, []byte{0x00, 0x00, 0x10, 0xC0}, "3.1", false},
+		{CN_STRING, []byte("SIT0001\x00"), "SIT0001", false},
+		{CN_UINT16, []byte{0x9F}, nil, true},
+		{CN_UINT32, []byte{0x84, 0x03}, nil, true},
+		{CN_VERSION, []byte{0x10, 0xC0}, nil, true},
+		{CN_INT64, []byte{0xFE, 0xFF, 0xFF, 0xFF}, nil, true},
+	} {
+		rmi := &ZehnderRMI{Data: tc.data, DataLength: len(tc.data)}
+		got, err := rmi.GetData(tc.typ)
+		if tc.invalid {
+			if err == nil {
+				t.Errorf("%s % X: extracted %v, expected an error", tc.typ, tc.data, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s % X: %v", tc.typ, tc.data, err)
+		} else if got != tc.expected {
+			t.Errorf("%s % X: got %v, expected %v", tc.typ, tc.data, got, tc.expected)
+		}
+	}
+
+	// values are read in turn, leaving the position unchanged on errors
+	rmi := &ZehnderRMI{Data: []byte{0x01, 0x10, 0xC0}, DataLength: 3}
+	if got, err := rmi.GetData(CN_UINT8); err != nil || got != uint(1) {
+		t.Errorf("first value is %v (%v), expected 1", got, err)
+	}
+	if _, err := rmi.GetData(CN_VERSION); err == nil {
+		t.Error("version extracted from 2 bytes")
+	}
+	if got, err := rmi.GetData(CN_UINT16); err != nil || got != uint(0xC010) {
+		t.Errorf("second value is %v (%v), expected %d", got, err, 0xC010)
+	}
+}
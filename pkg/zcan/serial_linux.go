@@ -0,0 +1,49 @@
+//go:build linux
+
+package zcan
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// openSerialPort opens a serial device in raw mode at 115200 baud. USB
+// adapters ignore the baud rate but real UARTs need it set.
+func openSerialPort(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|unix.O_NOCTTY|unix.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := f.SyscallConn()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	var termErr error
+	err = rc.Control(func(fd uintptr) {
+		var t *unix.Termios
+		t, termErr = unix.IoctlGetTermios(int(fd), unix.TCGETS)
+		if termErr != nil {
+			return
+		}
+		t.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+		t.Oflag &^= unix.OPOST
+		t.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+		t.Cflag &^= unix.CSIZE | unix.PARENB | unix.CBAUD
+		t.Cflag |= unix.CS8 | unix.CREAD | unix.CLOCAL | unix.B115200
+		t.Ispeed = unix.B115200
+		t.Ospeed = unix.B115200
+		t.Cc[unix.VMIN] = 1
+		t.Cc[unix.VTIME] = 0
+		termErr = unix.IoctlSetTermios(int(fd), unix.TCSETS, t)
+	})
+	if err == nil {
+		err = termErr
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
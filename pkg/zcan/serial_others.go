@@ -0,0 +1,12 @@
+//go:build !linux
+
+package zcan
+
+import (
+	"fmt"
+	"os"
+)
+
+func openSerialPort(path string) (*os.File, error) {
+	return nil, fmt.Errorf("serial ports are only supported on linux")
+}
@@ -0,0 +1,348 @@
+package zcan
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"log/slog"
+	"sync"
+	"time"
+
+	"go.einride.tech/can"
+)
+
+// RMI error codes returned by the simulator.
+const (
+	rmiErrorUnknownCommand  byte = 11
+	rmiErrorUnknownProperty byte = 13
+	rmiErrorInvalidData     byte = 14
+)
+
+type simProperty struct {
+	unit    byte
+	subunit byte
+	prop    byte
+}
+
+type simSubscription struct {
+	interval byte
+	stop     chan struct{}
+}
+
+// Simulator is a stand-in for a ComfoAir Q unit. It answers PDO requests
+// for the sensors in sensorData, responds to RMI GetOne, GetMultiple and
+// SetOne requests and emits heartbeats, allowing zcan to be developed and
+// tested on a VirtualBus or vcan interface.
+type Simulator struct {
+	NodeID byte
+	Logger *slog.Logger
+
+	transport Transport
+
+	mu            sync.Mutex
+	txMu          sync.Mutex
+	pdoValues     map[int][]byte
+	subscriptions map[int]*simSubscription
+	properties    map[simProperty][]byte
+	rmiHolders    map[byte]*ZehnderRMI
+
+	stopped    bool
+	stopOnce   sync.Once
+	stopSignal chan struct{}
+	wg         sync.WaitGroup
+}
+
+var simulatorPDODefaults = map[int][]byte{
+	49:  {0x01},
+	65:  {0x02},
+	117: {0x38},
+	118: {0x3C},
+	119: {0xFB, 0x00},
+	120: {0xFA, 0x00},
+	121: {0x3D, 0x08},
+	122: {0xB7, 0x08},
+	128: {0x38, 0x00},
+	192: {0x8C, 0x00},
+	209: {0x8C, 0x00},
+	213: {0x9F, 0x03},
+	227: {0x00},
+	274: {0xD2, 0x00},
+	275: {0x6E, 0x00},
+	276: {0x5A, 0x00},
+	278: {0xC8, 0x00},
+	290: {0x2D},
+	291: {0x41},
+	292: {0x50},
+	294: {0x2F},
+}
+
+// NewSimulator returns a simulated ComfoAir Q unit with node ID 1 that will
+// use the supplied transport once started.
+func NewSimulator(t Transport) *Simulator {
+	sim := &Simulator{
+		NodeID:        1,
+		Logger:        slog.Default(),
+		transport:     t,
+		pdoValues:     make(map[int][]byte),
+		subscriptions: make(map[int]*simSubscription),
+		properties:    make(map[simProperty][]byte),
+		rmiHolders:    make(map[byte]*ZehnderRMI),
+	}
+	for pdo, sensor := range sensorData {
+		val, ck := simulatorPDODefaults[pdo]
+		if !ck {
+			val = make([]byte, sensor.DataType.size())
+		}
+		sim.pdoValues[pdo] = val
+	}
+
+	version := make([]byte, 4)
+	binary.LittleEndian.PutUint32(version, 3<<30|1<<20)
+	sim.SetProperty(1, 1, 4, append([]byte("SIT0000000000"), 0))
+	sim.SetProperty(1, 1, 6, version)
+	sim.SetProperty(1, 1, 8, append([]byte("ComfoAir Q450 GB ST ERV"), 0))
+	return sim
+}
+
+// SetPDO changes the value reported for a PDO. Nodes that requested the
+// PDO with an interval of 0xFF are sent the new value immediately.
+func (sim *Simulator) SetPDO(pdo int, value []byte) {
+	sim.mu.Lock()
+	changed := !bytes.Equal(sim.pdoValues[pdo], value)
+	sim.pdoValues[pdo] = append([]byte{}, value...)
+	sub := sim.subscriptions[pdo]
+	sim.mu.Unlock()
+
+	if changed && sub != nil && sub.interval == 0xFF {
+		sim.sendPDO(pdo)
+	}
+}
+
+// SetProperty sets the raw value of an RMI property.
+func (sim *Simulator) SetProperty(unit, subunit, prop byte, value []byte) {
+	sim.mu.Lock()
+	sim.properties[simProperty{unit, subunit, prop}] = append([]byte{}, value...)
+	sim.mu.Unlock()
+}
+
+// Property returns the raw value of an RMI property, which will reflect any
+// SetOne requests received.
+func (sim *Simulator) Property(unit, subunit, prop byte) ([]byte, bool) {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	val, ck := sim.properties[simProperty{unit, subunit, prop}]
+	return val, ck
+}
+
+func (sim *Simulator) Start() error {
+	if err := sim.transport.Open(); err != nil {
+		return err
+	}
+	sim.stopSignal = make(chan struct{})
+	sim.wg.Add(2)
+	go sim.receiver()
+	go sim.heartbeat()
+	return nil
+}
+
+// Stop stops the simulator and waits for its goroutines to finish. It may
+// be called more than once.
+func (sim *Simulator) Stop() {
+	sim.stopOnce.Do(func() {
+		if sim.stopSignal != nil {
+			close(sim.stopSignal)
+		}
+		sim.mu.Lock()
+		sim.stopped = true
+		for pdo, sub := range sim.subscriptions {
+			close(sub.stop)
+			delete(sim.subscriptions, pdo)
+		}
+		sim.mu.Unlock()
+		sim.transport.Close()
+		sim.wg.Wait()
+	})
+}
+
+func (sim *Simulator) transmit(frame can.Frame) {
+	sim.txMu.Lock()
+	defer sim.txMu.Unlock()
+	if err := sim.transport.Transmit(context.Background(), frame); err != nil {
+		sim.Logger.Warn("simulator unable to transmit frame", "frame", frame, "error", err)
+	}
+}
+
+func (sim *Simulator) receiver() {
+	defer sim.wg.Done()
+	for {
+		frame, err := sim.transport.Receive()
+		if err != nil {
+			return
+		}
+		switch frame.ID >> 24 {
+		case 0:
+			sim.processPDORequest(frame.Frame)
+		case 0x1F:
+			sim.processRMIRequest(frame.Frame)
+		case 0x10:
+			if frame.IsRemote && byte(frame.ID&0x3F) == sim.NodeID {
+				sim.transmit(sim.heartbeatFrame())
+			}
+		}
+	}
+}
+
+func (sim *Simulator) heartbeatFrame() can.Frame {
+	return can.Frame{ID: 0x10000000 + uint32(sim.NodeID), IsExtended: true}
+}
+
+func (sim *Simulator) heartbeat() {
+	defer sim.wg.Done()
+	sim.transmit(sim.heartbeatFrame())
+	timer := time.NewTicker(time.Second)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			sim.transmit(sim.heartbeatFrame())
+		case <-sim.stopSignal:
+			return
+		}
+	}
+}
+
+func (sim *Simulator) processPDORequest(frame can.Frame) {
+	if !frame.IsRemote || byte(frame.ID&0x3F) != sim.NodeID {
+		return
+	}
+	pdo := int(frame.ID>>14) & 0x7FF
+	var interval byte
+	if frame.Length > 0 {
+		interval = frame.Data[0]
+	}
+
+	sim.mu.Lock()
+	if sub, ck := sim.subscriptions[pdo]; ck {
+		close(sub.stop)
+		delete(sim.subscriptions, pdo)
+	}
+	_, known := sim.pdoValues[pdo]
+	if sim.stopped || !known || interval == 0 {
+		sim.mu.Unlock()
+		return
+	}
+	sub := &simSubscription{interval: interval, stop: make(chan struct{})}
+	sim.subscriptions[pdo] = sub
+	if interval != 0xFF {
+		// added while holding mu so that Stop can't be waiting already
+		sim.wg.Add(1)
+		go sim.publishPDO(pdo, sub)
+	}
+	sim.mu.Unlock()
+
+	sim.sendPDO(pdo)
+}
+
+func (sim *Simulator) publishPDO(pdo int, sub *simSubscription) {
+	defer sim.wg.Done()
+	timer := time.NewTicker(time.Duration(sub.interval) * time.Second)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			sim.sendPDO(pdo)
+		case <-sub.stop:
+			return
+		}
+	}
+}
+
+func (sim *Simulator) sendPDO(pdo int) {
+	sim.mu.Lock()
+	val := sim.pdoValues[pdo]
+	sim.mu.Unlock()
+
+	frame := can.Frame{ID: uint32(pdo&0x7FF)<<14 + 0x40 + uint32(sim.NodeID), IsExtended: true}
+	frame.Length = uint8(copy(frame.Data[:], val))
+	sim.transmit(frame)
+}
+
+func (sim *Simulator) processRMIRequest(frame can.Frame) {
+	rmi, err := rmiFromFrame(frame)
+	if err != nil || rmi.DestId != sim.NodeID || !rmi.IsRequest {
+		return
+	}
+	if rmi.IsMulti {
+		holder, ck := sim.rmiHolders[rmi.SourceId]
+		if ck {
+			holder.appendRMI(rmi)
+		} else {
+			holder = rmi
+			sim.rmiHolders[rmi.SourceId] = holder
+		}
+		if !holder.finalSeen {
+			return
+		}
+		delete(sim.rmiHolders, rmi.SourceId)
+		rmi = holder
+	}
+
+	data, errCode := sim.handleRMI(rmi.Data[:rmi.DataLength])
+	reply := ZehnderRMI{SourceId: sim.NodeID, DestId: rmi.SourceId, Sequence: rmi.Sequence}
+	if errCode != 0 {
+		reply.IsError = true
+		data = []byte{errCode}
+	}
+	reply.Data = data
+	reply.DataLength = len(data)
+	reply.IsMulti = reply.DataLength > 8
+	for _, f := range reply.frames() {
+		sim.transmit(f)
+	}
+}
+
+func (sim *Simulator) handleRMI(req []byte) ([]byte, byte) {
+	if len(req) < 3 {
+		return nil, rmiErrorInvalidData
+	}
+	unit, subunit := req[1], req[2]
+
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+
+	switch req[0] {
+	case 0x01:
+		if len(req) < 5 {
+			return nil, rmiErrorInvalidData
+		}
+		val, ck := sim.properties[simProperty{unit, subunit, req[4]}]
+		if !ck {
+			return nil, rmiErrorUnknownProperty
+		}
+		return val, 0
+	case 0x02:
+		if len(req) < 5 {
+			return nil, rmiErrorInvalidData
+		}
+		count := int(req[4] & 0x0F)
+		if len(req) < 5+count {
+			return nil, rmiErrorInvalidData
+		}
+		var rv []byte
+		for _, prop := range req[5 : 5+count] {
+			val, ck := sim.properties[simProperty{unit, subunit, prop}]
+			if !ck {
+				return nil, rmiErrorUnknownProperty
+			}
+			rv = append(rv, val...)
+		}
+		return rv, 0
+	case 0x03:
+		if len(req) < 5 {
+			return nil, rmiErrorInvalidData
+		}
+		sim.properties[simProperty{unit, subunit, req[3]}] = append([]byte{}, req[4:]...)
+		return []byte{}, 0
+	}
+	return nil, rmiErrorUnknownCommand
+}
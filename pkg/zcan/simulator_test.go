@@ -0,0 +1,154 @@
+package zcan
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"go.einride.tech/can"
+)
+
+var testLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func TestSimulatorRMI(t *testing.T) {
+	bus, dev := startVirtual(t)
+	sim := NewSimulator(bus.Attach(false))
+	if err := sim.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer sim.Stop()
+
+	replies := make(chan *ZehnderRMI, 1)
+	NewZehnderDestination(1, 1, 1).GetMultiple(dev, []byte{4, 6, 8}, ZehnderRMITypeActualValue, func(rmi *ZehnderRMI) {
+		replies <- rmi
+	})
+	var rmi *ZehnderRMI
+	select {
+	case rmi = <-replies:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reply to GetMultiple")
+	}
+	if rmi.IsError {
+		t.Fatalf("GetMultiple failed with error %v", rmi.Data[:rmi.DataLength])
+	}
+	for _, tc := range []struct {
+		typ      ZehnderType
+		expected string
+	}{
+		{CN_STRING, "SIT0000000000"},
+		{CN_VERSION, "3.1"},
+		{CN_STRING, "ComfoAir Q450 GB ST ERV"},
+	} {
+		val, err := rmi.GetData(tc.typ)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if val != tc.expected {
+			t.Errorf("got %v, expected %s", val, tc.expected)
+		}
+	}
+
+	value := []byte{1, 2, 3, 4, 5, 6}
+	dev.SetDefaultRMICallback(func(rmi *ZehnderRMI) { replies <- rmi })
+	NewZehnderDestination(1, 0x1E, 1).SetOne(dev, 3, value)
+	select {
+	case rmi = <-replies:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reply to SetOne")
+	}
+	if rmi.IsError {
+		t.Fatalf("SetOne failed with error %v", rmi.Data[:rmi.DataLength])
+	}
+	if got, _ := sim.Property(0x1E, 1, 3); !bytes.Equal(got, value) {
+		t.Errorf("property is %v, expected %v", got, value)
+	}
+}
+
+func TestSimulatorStop(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		bus := NewVirtualBus()
+		sim := NewSimulator(bus.Attach(false))
+		sim.Logger = testLogger
+		if err := sim.Start(); err != nil {
+			t.Fatal(err)
+		}
+		port := bus.Attach(false)
+		if err := port.Open(); err != nil {
+			t.Fatal(err)
+		}
+
+		// keep requesting PDOs while the simulator stops
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for pdo := uint16(1); pdo < 300; pdo++ {
+				port.Transmit(context.Background(), pdoRequest{1, pdo}.frame(1))
+			}
+		}()
+		sim.Stop()
+		<-done
+		sim.Stop()
+		port.Close()
+
+		sim.mu.Lock()
+		n := len(sim.subscriptions)
+		sim.mu.Unlock()
+		if n != 0 {
+			t.Fatalf("%d subscriptions remain after Stop", n)
+		}
+	}
+}
+
+// startSimulated starts a simulator and a device sharing a virtual bus,
+// stopping both when the test ends.
+func startSimulated(t *testing.T, opts ...Option) (*Simulator, *ZehnderDevice) {
+	t.Helper()
+	bus := NewVirtualBus()
+	sim := NewSimulator(bus.Attach(false))
+	sim.Logger = testLogger
+	if err := sim.Start(); err != nil {
+		t.Fatal(err)
+	}
+	opts = append([]Option{WithTransport(bus.Attach(false)), WithLogger(testLogger)}, opts...)
+	dev := NewZehnderDevice(55, opts...)
+	if err := dev.Start(context.Background()); err != nil {
+		sim.Stop()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		dev.Stop()
+		sim.Stop()
+	})
+	return sim, dev
+}
+
+func TestMalformedRMI(t *testing.T) {
+	sim, dev := startSimulated(t)
+
+	// a multi-frame RMI without the message number
+	empty := ZehnderRMI{SourceId: 1, DestId: dev.NodeID, IsMulti: true}
+	frame := can.Frame{ID: empty.MakeCANId(), IsExtended: true}
+	sim.processRMIRequest(frame)
+	sim.transmit(frame)
+	select {
+	case err := <-dev.Errors():
+		if err.Kind != ErrorDecode || err.Op != "rmi" {
+			t.Errorf("unexpected error %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("malformed RMI not reported")
+	}
+
+	replies := make(chan *ZehnderRMI, 1)
+	NewZehnderDestination(1, 1, 1).GetOne(dev, 6, ZehnderRMITypeActualValue, func(rmi *ZehnderRMI) {
+		replies <- rmi
+	})
+	select {
+	case <-replies:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reply after malformed RMI")
+	}
+}
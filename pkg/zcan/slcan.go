@@ -0,0 +1,215 @@
+package zcan
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.einride.tech/can"
+)
+
+// slcanBitrate50k is the slcan setup command for the 50 kbit/s bus used by
+// ComfoAir Q units.
+const slcanBitrate50k = "S2"
+
+// slcanReplyTimeout is how long the adapter has to reply to a command.
+const slcanReplyTimeout = time.Second
+
+// errSLCANRejected is returned when the adapter replies to a command with
+// a BEL.
+var errSLCANRejected = errors.New("command rejected")
+
+// slcanConnection is a Transport for serial-line CAN adapters that use the
+// Lawicel ASCII protocol, such as a CANable with slcan firmware or USBtin.
+type slcanConnection struct {
+	path string
+
+	mu     sync.Mutex
+	port   *os.File
+	reader *bufio.Reader
+}
+
+// NewSLCANTransport returns a Transport using the slcan adapter at the
+// supplied serial device path, e.g. /dev/ttyACM0.
+func NewSLCANTransport(path string) (Transport, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	return &slcanConnection{path: path}, nil
+}
+
+func (conn *slcanConnection) Open() error {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if conn.port != nil {
+		return nil
+	}
+	port, err := openSerialPort(conn.path)
+	if err != nil {
+		return err
+	}
+	// Close any channel left open, which is rejected if there isn't one,
+	// then set the bitrate and open it.
+	reader := bufio.NewReader(port)
+	for _, cmd := range []string{"C", slcanBitrate50k, "O"} {
+		err := slcanCommand(port, reader, cmd)
+		if err == errSLCANRejected && cmd == "C" {
+			continue
+		}
+		if err != nil {
+			port.Close()
+			return fmt.Errorf("slcan %s: %q: %w", conn.path, cmd, err)
+		}
+	}
+	conn.port = port
+	conn.reader = reader
+	return nil
+}
+
+// slcanCommand sends a command to the adapter and waits for its reply, a
+// CR if the command succeeded or a BEL if it didn't.
+func slcanCommand(port *os.File, reader *bufio.Reader, cmd string) error {
+	if _, err := port.WriteString(cmd + "\r"); err != nil {
+		return err
+	}
+	port.SetReadDeadline(time.Now().Add(slcanReplyTimeout))
+	defer port.SetReadDeadline(time.Time{})
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return fmt.Errorf("no reply: %w", err)
+		}
+		switch b {
+		case '\r':
+			return nil
+		case '\a':
+			return errSLCANRejected
+		}
+	}
+}
+
+func (conn *slcanConnection) state() (*os.File, *bufio.Reader) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	return conn.port, conn.reader
+}
+
+func (conn *slcanConnection) Receive() (TimedFrame, error) {
+	_, reader := conn.state()
+	if reader == nil {
+		return TimedFrame{}, io.EOF
+	}
+	for {
+		line, err := reader.ReadString('\r')
+		if err != nil {
+			return TimedFrame{}, err
+		}
+		// Command acknowledgements are a bare CR, errors a BEL and
+		// transmit confirmations a z or Z.
+		line = strings.TrimLeft(strings.TrimSuffix(line, "\r"), "\a")
+		if line == "" || line == "z" || line == "Z" {
+			continue
+		}
+		frame, err := slcanDecodeFrame(line)
+		if err != nil {
+			continue
+		}
+		return TimedFrame{Frame: frame, Timestamp: time.Now()}, nil
+	}
+}
+
+func (conn *slcanConnection) Transmit(ctx context.Context, frame can.Frame) error {
+	port, _ := conn.state()
+	if port == nil {
+		return fmt.Errorf("slcan %s is not open", conn.path)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		port.SetWriteDeadline(deadline)
+		defer port.SetWriteDeadline(time.Time{})
+	}
+	_, err := port.WriteString(slcanEncodeFrame(frame) + "\r")
+	return err
+}
+
+func (conn *slcanConnection) Close() error {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if conn.port == nil {
+		return nil
+	}
+	conn.port.WriteString("C\r")
+	err := conn.port.Close()
+	conn.port = nil
+	conn.reader = nil
+	return err
+}
+
+func slcanEncodeFrame(frame can.Frame) string {
+	var cmd string
+	switch {
+	case frame.IsExtended && frame.IsRemote:
+		cmd = fmt.Sprintf("R%08X", frame.ID)
+	case frame.IsExtended:
+		cmd = fmt.Sprintf("T%08X", frame.ID)
+	case frame.IsRemote:
+		cmd = fmt.Sprintf("r%03X", frame.ID)
+	default:
+		cmd = fmt.Sprintf("t%03X", frame.ID)
+	}
+	cmd += strconv.Itoa(int(frame.Length))
+	if !frame.IsRemote {
+		cmd += strings.ToUpper(hex.EncodeToString(frame.Data[:frame.Length]))
+	}
+	return cmd
+}
+
+func slcanDecodeFrame(line string) (can.Frame, error) {
+	frame := can.Frame{}
+	idLen := 3
+	switch line[0] {
+	case 'T':
+		frame.IsExtended = true
+		idLen = 8
+	case 'R':
+		frame.IsExtended = true
+		frame.IsRemote = true
+		idLen = 8
+	case 'r':
+		frame.IsRemote = true
+	case 't':
+	default:
+		return frame, fmt.Errorf("unknown slcan message %q", line)
+	}
+	if len(line) < idLen+2 {
+		return frame, fmt.Errorf("short slcan frame %q", line)
+	}
+	id, err := strconv.ParseUint(line[1:idLen+1], 16, 32)
+	if err != nil {
+		return frame, err
+	}
+	frame.ID = uint32(id)
+	dlc := int(line[idLen+1] - '0')
+	if dlc < 0 || dlc > 8 {
+		return frame, fmt.Errorf("invalid slcan data length in %q", line)
+	}
+	frame.Length = uint8(dlc)
+	if !frame.IsRemote {
+		// Anything after the data is an optional timestamp.
+		data := line[idLen+2:]
+		if len(data) < dlc*2 {
+			return frame, fmt.Errorf("short slcan frame %q", line)
+		}
+		if _, err := hex.Decode(frame.Data[:dlc], []byte(data[:dlc*2])); err != nil {
+			return frame, err
+		}
+	}
+	return frame, nil
+}
@@ -0,0 +1,122 @@
+package zcan
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"go.einride.tech/can"
+	"golang.org/x/sys/unix"
+)
+
+// openPty returns the master side of a new pty and the path of its slave.
+func openPty(t *testing.T) (*os.File, string) {
+	t.Helper()
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("no pty available: %v", err)
+	}
+	t.Cleanup(func() { master.Close() })
+	fd := int(master.Fd())
+	if err := unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+		t.Fatal(err)
+	}
+	n, err := unix.IoctlGetInt(fd, unix.TIOCGPTN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return master, fmt.Sprintf("/dev/pts/%d", n)
+}
+
+// fakeAdapter answers slcan commands on the master side of a pty, replying
+// with a BEL to those in reject, and passes transmitted frames to frames.
+func fakeAdapter(master *os.File, reject map[string]bool, frames chan<- string) {
+	reader := bufio.NewReader(master)
+	open := false
+	for {
+		line, err := reader.ReadString('\r')
+		if err != nil {
+			return
+		}
+		cmd := strings.TrimSuffix(line, "\r")
+		reply := "\r"
+		switch {
+		case reject[cmd], cmd == "C" && !open:
+			reply = "\a"
+		case cmd == "C":
+			open = false
+		case cmd == "O":
+			open = true
+		case strings.HasPrefix(cmd, "S"):
+		default:
+			frames <- cmd
+			reply = "z\r"
+		}
+		master.WriteString(reply)
+	}
+}
+
+func TestSLCANAdapter(t *testing.T) {
+	master, path := openPty(t)
+	frames := make(chan string, 1)
+	go fakeAdapter(master, nil, frames)
+
+	conn, err := NewSLCANTransport(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	frame := can.Frame{ID: 0x001E0041, IsExtended: true, Length: 2, Data: can.Data{0xFA, 0x00}}
+	if err := conn.Transmit(ctx, frame); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-frames:
+		if got != "T001E00412FA00" {
+			t.Errorf("adapter received %q", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("adapter received no frame")
+	}
+
+	// the write deadline must not outlive the transmit
+	<-ctx.Done()
+	if err := conn.Transmit(context.Background(), frame); err != nil {
+		t.Fatalf("transmit after deadline: %v", err)
+	}
+	<-frames
+
+	master.WriteString("t1232ABCD\r")
+	received, err := conn.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := can.Frame{ID: 0x123, Length: 2, Data: can.Data{0xAB, 0xCD}}
+	if received.Frame != expected {
+		t.Errorf("received %v, expected %v", received.Frame, expected)
+	}
+}
+
+func TestSLCANAdapterRejects(t *testing.T) {
+	master, path := openPty(t)
+	go fakeAdapter(master, map[string]bool{slcanBitrate50k: true}, make(chan string, 1))
+
+	conn, err := NewSLCANTransport(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Open(); err == nil {
+		conn.Close()
+		t.Fatal("open succeeded with the bitrate rejected")
+	}
+}
@@ -0,0 +1,127 @@
+//go:build linux
+
+package zcan
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"go.einride.tech/can/pkg/socketcan"
+	"golang.org/x/sys/unix"
+)
+
+// canErrorMask selects the error frames delivered to the socket.
+const canErrorMask = uint32(socketcan.ErrorClassTxTimeout | socketcan.ErrorClassController |
+	socketcan.ErrorClassBusOff | socketcan.ErrorClassRestarted)
+
+// dialCANRaw opens a raw CAN socket bound to the interface. Unlike
+// socketcan.Dial the socket is returned as a file so that options can be
+// set on it.
+func dialCANRaw(interfaceName string) (*os.File, error) {
+	ifi, err := net.InterfaceByName(interfaceName)
+	if err != nil {
+		return nil, fmt.Errorf("interface %s: %w", interfaceName, err)
+	}
+	fd, err := unix.Socket(unix.AF_CAN, unix.SOCK_RAW, unix.CAN_RAW)
+	if err != nil {
+		return nil, fmt.Errorf("socket: %w", err)
+	}
+	// non-blocking so the file is managed by the runtime poller
+	if err := unix.SetNonblock(fd, true); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("set nonblock: %w", err)
+	}
+	if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_TIMESTAMP, 1); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("set timestamp: %w", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrCAN{Ifindex: ifi.Index}); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("bind: %w", err)
+	}
+	return os.NewFile(uintptr(fd), "can:"+interfaceName), nil
+}
+
+// setCANFilters installs the receive filters and error mask on a raw CAN
+// socket. With no filters all frames are received.
+func setCANFilters(f *os.File, filters []CANFilter) error {
+	kf := []unix.CanFilter{{Id: 0, Mask: 0}}
+	if len(filters) > 0 {
+		kf = make([]unix.CanFilter, len(filters))
+		for n, filter := range filters {
+			kf[n] = unix.CanFilter{Id: filter.ID, Mask: filter.Mask}
+		}
+	}
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	err = rc.Control(func(fd uintptr) {
+		serr = unix.SetsockoptCanRawFilter(int(fd), unix.SOL_CAN_RAW, unix.CAN_RAW_FILTER, kf)
+		if serr == nil {
+			serr = unix.SetsockoptInt(int(fd), unix.SOL_CAN_RAW, unix.CAN_RAW_ERR_FILTER, int(canErrorMask))
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
+
+// canReader reads from a raw CAN socket, keeping the kernel receive
+// timestamp of the last frame read. Each read on a raw CAN socket returns a
+// single frame, so the timestamp applies to the frame the socketcan.Receiver
+// has just decoded.
+type canReader struct {
+	f    *os.File
+	rc   syscall.RawConn
+	oob  []byte
+	last time.Time
+}
+
+func newCANReader(f *os.File) (*canReader, error) {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	return &canReader{f: f, rc: rc, oob: make([]byte, unix.CmsgSpace(int(unsafe.Sizeof(unix.Timeval{}))))}, nil
+}
+
+func (r *canReader) Read(p []byte) (int, error) {
+	var n, oobn int
+	var rerr error
+	err := r.rc.Read(func(fd uintptr) bool {
+		n, oobn, _, _, rerr = unix.Recvmsg(int(fd), p, r.oob, 0)
+		return rerr != unix.EAGAIN
+	})
+	if err != nil {
+		return 0, err
+	}
+	if rerr != nil {
+		return 0, rerr
+	}
+	r.last = time.Now()
+	if msgs, err := unix.ParseSocketControlMessage(r.oob[:oobn]); err == nil {
+		for _, msg := range msgs {
+			if msg.Header.Level == unix.SOL_SOCKET && msg.Header.Type == unix.SO_TIMESTAMP &&
+				len(msg.Data) >= int(unsafe.Sizeof(unix.Timeval{})) {
+				tv := (*unix.Timeval)(unsafe.Pointer(&msg.Data[0]))
+				r.last = time.Unix(tv.Unix())
+			}
+		}
+	}
+	return n, nil
+}
+
+func (r *canReader) Close() error {
+	return r.f.Close()
+}
+
+func (r *canReader) timestamp() time.Time {
+	return r.last
+}
@@ -0,0 +1,37 @@
+//go:build !linux
+
+package zcan
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+func dialCANRaw(interfaceName string) (*os.File, error) {
+	return nil, fmt.Errorf("socketcan is only supported on linux")
+}
+
+func setCANFilters(f *os.File, filters []CANFilter) error {
+	return fmt.Errorf("socketcan is only supported on linux")
+}
+
+type canReader struct {
+	f *os.File
+}
+
+func newCANReader(f *os.File) (*canReader, error) {
+	return nil, fmt.Errorf("socketcan is only supported on linux")
+}
+
+func (r *canReader) Read(p []byte) (int, error) {
+	return r.f.Read(p)
+}
+
+func (r *canReader) Close() error {
+	return r.f.Close()
+}
+
+func (r *canReader) timestamp() time.Time {
+	return time.Now()
+}
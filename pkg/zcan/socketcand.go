@@ -0,0 +1,262 @@
+package zcan
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.einride.tech/can"
+)
+
+const socketcandDefaultPort = "29536"
+
+// socketcandConnection is a Transport that uses the rawmode of a socketcand
+// server to access a remote bus.
+type socketcandConnection struct {
+	address string
+	bus     string
+
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// NewSocketcandTransport returns a Transport for the named bus on the
+// socketcand server at address. The default socketcand port is used if the
+// address doesn't include one.
+func NewSocketcandTransport(address, bus string) (Transport, error) {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, socketcandDefaultPort)
+	}
+	if bus == "" {
+		return nil, fmt.Errorf("socketcand requires a bus name")
+	}
+	return &socketcandConnection{address: address, bus: bus}, nil
+}
+
+func (conn *socketcandConnection) Open() error {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if conn.conn != nil {
+		return nil
+	}
+	c, err := net.DialTimeout("tcp", conn.address, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	reader := bufio.NewReader(c)
+	steps := []struct {
+		send   string
+		expect string
+	}{
+		{"", "hi"},
+		{"open " + conn.bus, "ok"},
+		{"rawmode", "ok"},
+	}
+	for _, step := range steps {
+		if step.send != "" {
+			if _, err := fmt.Fprintf(c, "< %s >", step.send); err != nil {
+				c.Close()
+				return err
+			}
+		}
+		reply, err := socketcandReadElement(reader)
+		if err != nil {
+			c.Close()
+			return err
+		}
+		if reply != step.expect {
+			c.Close()
+			return fmt.Errorf("socketcand %s: expected '%s' but got '%s'", conn.address, step.expect, reply)
+		}
+	}
+	conn.conn = c
+	conn.reader = reader
+	return nil
+}
+
+// Receive timestamps frames as they are read, as the server's clock may not
+// agree with ours.
+func (conn *socketcandConnection) Receive() (TimedFrame, error) {
+	conn.mu.Lock()
+	reader := conn.reader
+	conn.mu.Unlock()
+	if reader == nil {
+		return TimedFrame{}, io.EOF
+	}
+	for {
+		element, err := socketcandReadElement(reader)
+		if err != nil {
+			return TimedFrame{}, err
+		}
+		fields := strings.Fields(element)
+		if len(fields) == 0 || fields[0] != "frame" {
+			continue
+		}
+		frame, _, err := socketcandDecodeFrame(fields[1:])
+		if err != nil {
+			continue
+		}
+		return TimedFrame{Frame: frame, Timestamp: time.Now()}, nil
+	}
+}
+
+func (conn *socketcandConnection) Transmit(ctx context.Context, frame can.Frame) error {
+	conn.mu.Lock()
+	c := conn.conn
+	conn.mu.Unlock()
+	if c == nil {
+		return fmt.Errorf("socketcand connection to %s is not open", conn.address)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		c.SetWriteDeadline(deadline)
+		defer c.SetWriteDeadline(time.Time{})
+	}
+	_, err := io.WriteString(c, socketcandEncodeSend(frame))
+	return err
+}
+
+func (conn *socketcandConnection) Close() error {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if conn.conn == nil {
+		return nil
+	}
+	err := conn.conn.Close()
+	conn.conn = nil
+	conn.reader = nil
+	return err
+}
+
+// socketcandReadElement returns the contents of the next < ... > element.
+func socketcandReadElement(reader *bufio.Reader) (string, error) {
+	if _, err := reader.ReadString('<'); err != nil {
+		return "", err
+	}
+	element, err := reader.ReadString('>')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(strings.TrimSuffix(element, ">")), nil
+}
+
+// socketcandFormatID formats a CAN ID as socketcand does, using 8 digits
+// for extended IDs. Remote frames have the Linux RTR flag, and the EFF flag
+// if extended, included in the ID, which socketcand passes through to the
+// kernel.
+func socketcandFormatID(frame can.Frame) string {
+	id := frame.ID
+	if frame.IsRemote {
+		id |= canRTRFlag
+		if frame.IsExtended {
+			id |= canEFFFlag
+		}
+	}
+	if frame.IsExtended || frame.IsRemote {
+		return fmt.Sprintf("%08X", id)
+	}
+	return fmt.Sprintf("%03X", id)
+}
+
+func socketcandParseID(s string) (can.Frame, error) {
+	frame := can.Frame{}
+	id, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return frame, err
+	}
+	frame.IsRemote = id&canRTRFlag != 0
+	if frame.IsRemote {
+		frame.IsExtended = id&canEFFFlag != 0
+	} else {
+		frame.IsExtended = len(s) == 8
+	}
+	if frame.IsExtended {
+		frame.ID = uint32(id) & canEFFMask
+	} else {
+		frame.ID = uint32(id) & canSFFMask
+	}
+	return frame, nil
+}
+
+func socketcandEncodeSend(frame can.Frame) string {
+	s := fmt.Sprintf("< send %s %d", socketcandFormatID(frame), frame.Length)
+	if !frame.IsRemote {
+		for _, b := range frame.Data[:frame.Length] {
+			s += fmt.Sprintf(" %02X", b)
+		}
+	}
+	return s + " >"
+}
+
+func socketcandEncodeFrame(frame can.Frame, ts time.Time) string {
+	data := ""
+	if !frame.IsRemote {
+		data = strings.ToUpper(hex.EncodeToString(frame.Data[:frame.Length])) + " "
+	}
+	return fmt.Sprintf("< frame %s %d.%06d %s>", socketcandFormatID(frame), ts.Unix(), ts.Nanosecond()/1000, data)
+}
+
+// socketcandDecodeFrame decodes the fields following the frame command, i.e.
+// the ID, timestamp and optional hex data.
+func socketcandDecodeFrame(fields []string) (can.Frame, time.Time, error) {
+	if len(fields) < 2 {
+		return can.Frame{}, time.Time{}, fmt.Errorf("short socketcand frame")
+	}
+	frame, err := socketcandParseID(fields[0])
+	if err != nil {
+		return frame, time.Time{}, err
+	}
+	var ts time.Time
+	if secs, err := strconv.ParseFloat(fields[1], 64); err == nil {
+		ts = time.Unix(0, int64(secs*1e9))
+	}
+	if len(fields) > 2 {
+		// Data is normally a single hex string but some servers space
+		// the bytes.
+		hexData := strings.Join(fields[2:], "")
+		data, err := hex.DecodeString(hexData)
+		if err != nil || len(data) > 8 {
+			return frame, ts, fmt.Errorf("invalid socketcand frame data '%s'", hexData)
+		}
+		frame.Length = uint8(copy(frame.Data[:], data))
+	}
+	return frame, ts, nil
+}
+
+// socketcandDecodeSend decodes the fields following the send command, i.e.
+// the ID, length and data bytes.
+func socketcandDecodeSend(fields []string) (can.Frame, error) {
+	if len(fields) < 2 {
+		return can.Frame{}, fmt.Errorf("short socketcand send")
+	}
+	frame, err := socketcandParseID(fields[0])
+	if err != nil {
+		return frame, err
+	}
+	length, err := strconv.Atoi(fields[1])
+	if err != nil || length < 0 || length > 8 {
+		return frame, fmt.Errorf("invalid socketcand length '%s'", fields[1])
+	}
+	frame.Length = uint8(length)
+	if frame.IsRemote {
+		return frame, nil
+	}
+	if len(fields)-2 < length {
+		return frame, fmt.Errorf("socketcand send has too few data bytes")
+	}
+	for n := 0; n < length; n++ {
+		b, err := strconv.ParseUint(fields[2+n], 16, 8)
+		if err != nil {
+			return frame, err
+		}
+		frame.Data[n] = byte(b)
+	}
+	return frame, nil
+}
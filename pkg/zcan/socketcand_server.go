@@ -0,0 +1,189 @@
+package zcan
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+)
+
+const socketcandClientQueueSize = 256
+
+// socketcandServer exposes the bus used by a ZehnderDevice to socketcand
+// clients such as python-can, Kayak or SavvyCAN. Only rawmode is supported.
+// Frames received and transmitted by the device are sent to every client in
+// rawmode and frames sent by clients are transmitted by the device.
+type socketcandServer struct {
+	dev      *ZehnderDevice
+	bus      string
+	listener net.Listener
+	done     chan struct{}
+
+	mu      sync.Mutex
+	clients map[*socketcandClient]bool
+}
+
+type socketcandClient struct {
+	conn    net.Conn
+	out     chan string
+	rawmode bool
+}
+
+// StartSocketcandServer starts a socketcand compatible server on the host
+// and port given, offering the device's bus under the supplied bus name. It
+// should be called once the device has been started and the server runs
+// until the device is stopped.
+func (dev *ZehnderDevice) StartSocketcandServer(host string, port int, bus string) error {
+	if !dev.hasNetwork() {
+		return fmt.Errorf("socketcand server requires a bus connection")
+	}
+	l, err := net.Listen("tcp", fmt.Sprintf("%s:%d", host, port))
+	if err != nil {
+		return err
+	}
+	// Clients expect to see all traffic, not just the frames we handle.
+	if err := dev.AddReceiveFilter(CANFilter{ID: 0, Mask: 0}); err != nil {
+		l.Close()
+		return err
+	}
+	srv := &socketcandServer{
+		dev:      dev,
+		bus:      bus,
+		listener: l,
+		done:     make(chan struct{}),
+		clients:  make(map[*socketcandClient]bool),
+	}
+	if !dev.goroutine(srv.serve) {
+		l.Close()
+		return fmt.Errorf("socketcand server requires the device to be running")
+	}
+	dev.addFrameTap(srv.broadcast)
+	dev.logger.Info("starting socketcand server", "address", l.Addr(), "bus", bus)
+	return nil
+}
+
+func (srv *socketcandServer) serve() error {
+	go func() {
+		<-srv.dev.quit
+		srv.close()
+	}()
+	for {
+		conn, err := srv.listener.Accept()
+		if err != nil {
+			select {
+			case <-srv.done:
+				srv.dev.logger.Info("socketcand server shutdown")
+				return nil
+			default:
+			}
+			err = fmt.Errorf("socketcand server: %w", err)
+			srv.dev.reportError(ErrorIO, "socketcand", err)
+			return err
+		}
+		client := &socketcandClient{conn: conn, out: make(chan string, socketcandClientQueueSize)}
+		srv.mu.Lock()
+		srv.clients[client] = true
+		srv.mu.Unlock()
+		go srv.writer(client)
+		go srv.handle(client)
+	}
+}
+
+func (srv *socketcandServer) close() {
+	close(srv.done)
+	srv.listener.Close()
+	srv.mu.Lock()
+	for client := range srv.clients {
+		client.conn.Close()
+	}
+	srv.mu.Unlock()
+}
+
+// broadcast is the frame tap, so must never block. Clients that can't keep
+// up miss frames.
+func (srv *socketcandServer) broadcast(frame TimedFrame) {
+	msg := socketcandEncodeFrame(frame.Frame, frame.Timestamp)
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	for client := range srv.clients {
+		if !client.rawmode {
+			continue
+		}
+		select {
+		case client.out <- msg:
+		default:
+		}
+	}
+}
+
+func (srv *socketcandServer) writer(client *socketcandClient) {
+	for msg := range client.out {
+		if _, err := io.WriteString(client.conn, msg); err != nil {
+			client.conn.Close()
+		}
+	}
+}
+
+func (srv *socketcandServer) reply(client *socketcandClient, msg string) {
+	select {
+	case client.out <- msg:
+	case <-srv.done:
+	}
+}
+
+func (srv *socketcandServer) handle(client *socketcandClient) {
+	defer func() {
+		srv.mu.Lock()
+		delete(srv.clients, client)
+		srv.mu.Unlock()
+		client.conn.Close()
+		close(client.out)
+	}()
+
+	srv.reply(client, "< hi >")
+	reader := bufio.NewReader(client.conn)
+	opened := false
+	for {
+		element, err := socketcandReadElement(reader)
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(element)
+		if len(fields) == 0 {
+			continue
+		}
+		switch {
+		case fields[0] == "echo":
+			srv.reply(client, "< echo >")
+		case fields[0] == "open" && !opened:
+			if len(fields) != 2 || fields[1] != srv.bus {
+				srv.reply(client, "< error could not open bus >")
+				continue
+			}
+			opened = true
+			srv.reply(client, "< ok >")
+		case fields[0] == "rawmode" && opened:
+			srv.mu.Lock()
+			client.rawmode = true
+			srv.mu.Unlock()
+			srv.reply(client, "< ok >")
+		case fields[0] == "send" && client.rawmode:
+			frame, err := socketcandDecodeSend(fields[1:])
+			if err != nil {
+				srv.reply(client, "< error "+err.Error()+" >")
+				continue
+			}
+			if srv.dev.listenOnly {
+				srv.reply(client, "< error device is listen-only >")
+				continue
+			}
+			if !srv.dev.sendFrame(frame) {
+				return
+			}
+		default:
+			srv.reply(client, "< error unknown command >")
+		}
+	}
+}
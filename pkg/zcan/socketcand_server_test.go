@@ -0,0 +1,66 @@
+package zcan
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSocketcandServerListenOnly(t *testing.T) {
+	_, dev := startSimulated(t, WithListenOnly())
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	if err := dev.StartSocketcandServer("127.0.0.1", port, "can0"); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+
+	// exchange sends a command and returns the reply, skipping any frames
+	exchange := func(cmd string) string {
+		t.Helper()
+		if cmd != "" {
+			if _, err := io.WriteString(conn, cmd); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for {
+			element, err := socketcandReadElement(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(element, "frame ") {
+				return element
+			}
+		}
+	}
+	for _, tc := range []struct {
+		cmd      string
+		expected string
+	}{
+		{"", "hi"},
+		{"< open can0 >", "ok"},
+		{"< rawmode >", "ok"},
+		{"< send 00000123 1 AA >", "error device is listen-only"},
+		{"< echo >", "echo"},
+	} {
+		if got := exchange(tc.cmd); got != tc.expected {
+			t.Errorf("%q: got reply %q, expected %q", tc.cmd, got, tc.expected)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package zcan
+
+import (
+	"slices"
+	"time"
+
+	"go.einride.tech/can"
+)
+
+type ConnectionState int
+
+const (
+	StateDisconnected ConnectionState = iota
+	StateConnected
+	StateReconnecting
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case StateDisconnected:
+		return "disconnected"
+	case StateConnected:
+		return "connected"
+	case StateReconnecting:
+		return "reconnecting"
+	}
+	return "unknown"
+}
+
+// ConnectionStatus describes the current state of the device's connection
+// to the bus.
+type ConnectionStatus struct {
+	State      ConnectionState
+	Since      time.Time
+	LastError  error
+	Reconnects int
+}
+
+const (
+	reconnectMinBackoff = time.Second
+	reconnectMaxBackoff = time.Minute
+)
+
+// Status returns the current connection status.
+func (dev *ZehnderDevice) Status() ConnectionStatus {
+	dev.stateMu.Lock()
+	defer dev.stateMu.Unlock()
+	return dev.status
+}
+
+// OnStateChange registers a function to be called whenever the connection
+// state changes. The error is the reason for the change, if any.
+func (dev *ZehnderDevice) OnStateChange(fn func(ConnectionState, error)) {
+	dev.stateMu.Lock()
+	dev.stateFns = append(dev.stateFns, fn)
+	dev.stateMu.Unlock()
+}
+
+func (dev *ZehnderDevice) setState(state ConnectionState, err error) {
+	dev.stateMu.Lock()
+	changed := dev.status.State != state
+	if changed {
+		dev.status.Since = time.Now()
+		if state == StateReconnecting {
+			dev.status.Reconnects++
+		}
+	}
+	dev.status.State = state
+	if err != nil {
+		dev.status.LastError = err
+	}
+	dev.Connected = state == StateConnected
+	fns := dev.stateFns
+	dev.stateMu.Unlock()
+
+	if !changed {
+		return
+	}
+	dev.logger.Info("connection state changed", "state", state, "error", err)
+	dev.publishEvent(Event{Type: EventConnection, State: state, Err: err})
+	for _, fn := range fns {
+		fn(state, err)
+	}
+}
+
+// reconnect closes the transport and tries to reopen it, backing off between
+// attempts, until it succeeds or the device is stopped. Once reconnected the
+// heartbeat and all PDO requests are sent again.
+func (dev *ZehnderDevice) reconnect(reason error) bool {
+	dev.setState(StateReconnecting, reason)
+	dev.transport.Close()
+
+	backoff := reconnectMinBackoff
+	for {
+		select {
+		case <-time.After(backoff):
+		case <-dev.quit:
+			return false
+		}
+		err := dev.transport.Open()
+		if err == nil {
+			break
+		}
+		dev.reportError(ErrorTransport, "reconnect", err)
+		dev.setState(StateReconnecting, err)
+		backoff *= 2
+		if backoff > reconnectMaxBackoff {
+			backoff = reconnectMaxBackoff
+		}
+	}
+	// Stop may have closed the transport while it was being opened.
+	select {
+	case <-dev.quit:
+		dev.transport.Close()
+		return false
+	default:
+	}
+	dev.setState(StateConnected, nil)
+	dev.goroutine(func() error {
+		dev.resubscribe()
+		return nil
+	})
+	return true
+}
+
+// resubscribe sends the PDO requests made again, only to the nodes given
+// if any are.
+func (dev *ZehnderDevice) resubscribe(nodes ...byte) {
+	if !dev.sendFrame(dev.makeHeartbeatFrame()) {
+		return
+	}
+	dev.pdoMu.Lock()
+	requests := make(map[pdoRequest]byte, len(dev.pdoRequests))
+	for req, interval := range dev.pdoRequests {
+		if len(nodes) > 0 && !slices.Contains(nodes, req.product) {
+			continue
+		}
+		requests[req] = interval
+		dev.pdoRequested[req] = time.Now()
+	}
+	dev.pdoMu.Unlock()
+
+	for req, interval := range requests {
+		if !dev.sendFrame(req.frame(interval)) {
+			return
+		}
+	}
+}
+
+// sendFrame queues a frame for transmission, returning false if the device
+// was stopped first. Listen-only devices discard the frame.
+func (dev *ZehnderDevice) sendFrame(frame can.Frame) bool {
+	if dev.listenOnly {
+		dev.logger.Debug("listen-only, frame not sent", "frame", frame)
+		return true
+	}
+	select {
+	case dev.txQ <- frame:
+		return true
+	case <-dev.quit:
+		return false
+	}
+}
@@ -0,0 +1,161 @@
+package zcan
+
+import (
+	"bytes"
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.einride.tech/can"
+)
+
+// flakyPort is a VirtualPort that can be made to fail, and whose reopening
+// can be held up, to exercise reconnection.
+type flakyPort struct {
+	*VirtualPort
+	opens     atomic.Int32
+	reopening chan struct{}
+	release   chan struct{}
+}
+
+func newFlakyPort(bus *VirtualBus) *flakyPort {
+	return &flakyPort{VirtualPort: bus.Attach(false), reopening: make(chan struct{}, 1)}
+}
+
+func (port *flakyPort) Open() error {
+	if port.opens.Add(1) > 1 {
+		port.reopening <- struct{}{}
+		if port.release != nil {
+			<-port.release
+		}
+	}
+	return port.VirtualPort.Open()
+}
+
+// fail closes the port, which the device sees as the bus going away.
+func (port *flakyPort) fail() {
+	port.VirtualPort.Close()
+}
+
+// startFlaky starts a simulator and a device using a flakyPort.
+func startFlaky(t *testing.T, port *flakyPort, bus *VirtualBus) (*Simulator, *ZehnderDevice) {
+	t.Helper()
+	sim := NewSimulator(bus.Attach(false))
+	sim.Logger = testLogger
+	if err := sim.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(sim.Stop)
+	dev := NewZehnderDevice(55, WithTransport(port), WithLogger(testLogger))
+	if err := dev.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(dev.Stop)
+	return sim, dev
+}
+
+func TestReconnect(t *testing.T) {
+	bus := NewVirtualBus()
+	port := newFlakyPort(bus)
+	_, dev := startFlaky(t, port, bus)
+
+	sub := dev.Subscribe(PDOFilter{PDOs: []int{120}})
+	defer sub.Close()
+	next := func() PDOUpdate {
+		t.Helper()
+		select {
+		case update := <-sub.C:
+			return update
+		case <-time.After(5 * time.Second):
+			t.Fatal("no PDO update received")
+		}
+		return PDOUpdate{}
+	}
+
+	dev.RequestPDO(1, 120, pdoOnChange)
+	next()
+
+	port.fail()
+	select {
+	case <-port.reopening:
+	case <-time.After(5 * time.Second):
+		t.Fatal("device didn't reconnect")
+	}
+	// the PDO is requested again once reconnected
+	if update := next(); !bytes.Equal(update.Value.Value, []byte{0xFA, 0x00}) {
+		t.Errorf("unexpected update %+v", update)
+	}
+	status := dev.Status()
+	if status.State != StateConnected || status.Reconnects != 1 {
+		t.Errorf("status is %+v, expected connected after 1 reconnect", status)
+	}
+}
+
+func TestStopWhileReconnecting(t *testing.T) {
+	bus := NewVirtualBus()
+	port := newFlakyPort(bus)
+	port.release = make(chan struct{})
+	_, dev := startFlaky(t, port, bus)
+
+	port.fail()
+	select {
+	case <-port.reopening:
+	case <-time.After(5 * time.Second):
+		t.Fatal("device didn't reconnect")
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		dev.Stop()
+		close(stopped)
+	}()
+	// let Stop close the transport before the open completes
+	time.Sleep(50 * time.Millisecond)
+	close(port.release)
+	select {
+	case <-stopped:
+	case <-time.After(3 * time.Second):
+		t.Fatal("Stop hung while reconnecting")
+	}
+	if len(bus.ports) != 1 {
+		t.Errorf("bus has %d ports after stopping, expected only the simulator", len(bus.ports))
+	}
+}
+
+// stalledPort is a VirtualPort whose transmissions never complete.
+type stalledPort struct {
+	*VirtualPort
+	transmits atomic.Int32
+}
+
+func (port *stalledPort) Transmit(ctx context.Context, frame can.Frame) error {
+	port.transmits.Add(1)
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestStopWhileTransmitting(t *testing.T) {
+	bus := NewVirtualBus()
+	port := &stalledPort{VirtualPort: bus.Attach(false)}
+	dev := NewZehnderDevice(55, WithTransport(port), WithLogger(testLogger))
+	if err := dev.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	dev.RequestPDO(1, 120, pdoOnChange)
+	deadline := time.Now().Add(time.Second)
+	for port.transmits.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		dev.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(transmitTimeout / 2):
+		t.Fatal("Stop hung while transmitting")
+	}
+}
@@ -0,0 +1,199 @@
+package zcan
+
+import (
+	"sync"
+	"time"
+
+	"go.einride.tech/can"
+)
+
+// statsWindow is the number of seconds over which frame rates and bus load
+// are averaged.
+const statsWindow = 10
+
+// Stats holds the counters for the bus traffic seen by the device.
+type Stats struct {
+	Since           time.Time             `json:"since"`
+	RxFrames        uint64                `json:"rx_frames"`
+	TxFrames        uint64                `json:"tx_frames"`
+	TxErrors        uint64                `json:"tx_errors"`
+	PDOFrames       uint64                `json:"pdo_frames"`
+	RMIFrames       uint64                `json:"rmi_frames"`
+	HeartbeatFrames uint64                `json:"heartbeat_frames"`
+	UnknownFrames   uint64                `json:"unknown_frames"`
+	Errors          map[string]uint64     `json:"errors"`
+	Queues          map[string]QueueStats `json:"queues,omitempty"`
+	FramesPerSecond float64               `json:"frames_per_second"`
+	BusLoad         float64               `json:"bus_load"`
+	Controller      *ControllerStats      `json:"controller,omitempty"`
+}
+
+// ControllerStats describes the state of the CAN controller, where the
+// transport is able to report it.
+type ControllerStats struct {
+	State          string `json:"state"`
+	TxErrorCounter uint16 `json:"tx_error_counter"`
+	RxErrorCounter uint16 `json:"rx_error_counter"`
+	ErrorFrames    uint64 `json:"error_frames"`
+	BusOffEvents   uint64 `json:"bus_off_events"`
+}
+
+// ControllerStatsProvider is implemented by transports that can report the
+// state of the CAN controller.
+type ControllerStatsProvider interface {
+	ControllerStats() (ControllerStats, error)
+}
+
+type statsBucket struct {
+	second int64
+	frames uint64
+	bits   uint64
+}
+
+type busStats struct {
+	mu      sync.Mutex
+	stats   Stats
+	buckets [statsWindow]statsBucket
+	dropped map[string]uint64
+}
+
+// frameBits estimates the number of bits a frame occupies on the bus,
+// ignoring bit stuffing.
+func frameBits(frame can.Frame) uint64 {
+	bits := uint64(47)
+	if frame.IsExtended {
+		bits = 67
+	}
+	if !frame.IsRemote {
+		bits += 8 * uint64(frame.Length)
+	}
+	return bits
+}
+
+func (bs *busStats) reset() {
+	bs.mu.Lock()
+	bs.stats = Stats{Since: time.Now(), Errors: make(map[string]uint64)}
+	bs.buckets = [statsWindow]statsBucket{}
+	bs.dropped = make(map[string]uint64)
+	bs.mu.Unlock()
+}
+
+func (bs *busStats) addToWindow(frame can.Frame) {
+	now := time.Now().Unix()
+	b := &bs.buckets[now%statsWindow]
+	if b.second != now {
+		*b = statsBucket{second: now}
+	}
+	b.frames++
+	b.bits += frameBits(frame)
+}
+
+func (bs *busStats) received(frame can.Frame) {
+	bs.mu.Lock()
+	bs.stats.RxFrames++
+	bs.addToWindow(frame)
+	bs.mu.Unlock()
+}
+
+func (bs *busStats) transmitted(frame can.Frame) {
+	bs.mu.Lock()
+	bs.stats.TxFrames++
+	bs.addToWindow(frame)
+	bs.mu.Unlock()
+}
+
+func (bs *busStats) classified(msgType MsgType) {
+	bs.mu.Lock()
+	switch msgType {
+	case PDOData:
+		bs.stats.PDOFrames++
+	case RMI:
+		bs.stats.RMIFrames++
+	case HeartBeat:
+		bs.stats.HeartbeatFrames++
+	}
+	bs.mu.Unlock()
+}
+
+func (bs *busStats) unknown() {
+	bs.mu.Lock()
+	bs.stats.UnknownFrames++
+	bs.mu.Unlock()
+}
+
+func (bs *busStats) txError() {
+	bs.mu.Lock()
+	bs.stats.TxErrors++
+	bs.mu.Unlock()
+}
+
+// drop counts frames dropped from the named queue.
+func (bs *busStats) drop(queue string, n uint64) {
+	bs.mu.Lock()
+	if bs.dropped == nil {
+		bs.dropped = make(map[string]uint64)
+	}
+	bs.dropped[queue] += n
+	bs.mu.Unlock()
+}
+
+func (bs *busStats) drops() map[string]uint64 {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	rv := make(map[string]uint64, len(bs.dropped))
+	for queue, n := range bs.dropped {
+		rv[queue] = n
+	}
+	return rv
+}
+
+func (bs *busStats) error(kind ErrorKind) {
+	bs.mu.Lock()
+	if bs.stats.Errors == nil {
+		bs.stats.Errors = make(map[string]uint64)
+	}
+	bs.stats.Errors[kind.String()]++
+	bs.mu.Unlock()
+}
+
+// snapshot returns a copy of the stats with the rates calculated over the
+// last statsWindow complete seconds.
+func (bs *busStats) snapshot() Stats {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	st := bs.stats
+	st.Errors = make(map[string]uint64, len(bs.stats.Errors))
+	for kind, n := range bs.stats.Errors {
+		st.Errors[kind] = n
+	}
+	now := time.Now()
+	var frames, bits uint64
+	for _, b := range bs.buckets {
+		if b.second < now.Unix() && b.second >= now.Unix()-statsWindow {
+			frames += b.frames
+			bits += b.bits
+		}
+	}
+	window := float64(statsWindow)
+	if elapsed := now.Truncate(time.Second).Sub(st.Since).Seconds(); elapsed < window {
+		window = elapsed
+	}
+	if window > 0 {
+		st.FramesPerSecond = float64(frames) / window
+		st.BusLoad = float64(bits) / window / DefaultBitrate * 100
+	}
+	return st
+}
+
+// Stats returns the counters for the traffic seen since the device was
+// started. BusLoad is an estimate, as a percentage of the 50 kbit/s bus.
+func (dev *ZehnderDevice) Stats() Stats {
+	st := dev.stats.snapshot()
+	st.Queues = dev.queueStats()
+	if csp, ok := dev.transport.(ControllerStatsProvider); ok {
+		if cs, err := csp.ControllerStats(); err == nil {
+			st.Controller = &cs
+		}
+	}
+	return st
+}
@@ -0,0 +1,120 @@
+package zcan
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultStaleAfter is the number of intervals a requested PDO can be
+// missing for before its value is stale.
+const defaultStaleAfter = 3
+
+// PDOStore holds the latest value received for each PDO. It is safe for
+// concurrent use and only ever hands out copies of the values it holds.
+//
+// The interval each PDO was requested at is recorded, and values that
+// haven't been updated for staleAfter intervals are marked as stale.
+type PDOStore struct {
+	mu         sync.RWMutex
+	values     map[int]*PDOValue
+	intervals  map[int]time.Duration
+	staleAfter int
+}
+
+func newPDOStore() *PDOStore {
+	return &PDOStore{
+		values:     make(map[int]*PDOValue),
+		intervals:  make(map[int]time.Duration),
+		staleAfter: defaultStaleAfter,
+	}
+}
+
+// setInterval records how often the PDO is expected, with 0 for PDOs that
+// aren't sent at a fixed interval.
+func (st *PDOStore) setInterval(pdo int, interval time.Duration) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if interval == 0 {
+		delete(st.intervals, pdo)
+	} else {
+		st.intervals[pdo] = interval
+	}
+}
+
+// stale reports whether a PDO expected at the interval given, and last
+// updated at the time given, is stale.
+func (st *PDOStore) stale(interval time.Duration, updated time.Time, now time.Time) bool {
+	return interval > 0 && now.Sub(updated) > time.Duration(st.staleAfter)*interval
+}
+
+// copyAt returns a copy of the value with its freshness at the time given.
+func (st *PDOStore) copyAt(pv *PDOValue, now time.Time) PDOValue {
+	rv := pv.copy()
+	rv.Interval = st.intervals[pv.ID]
+	rv.Stale = st.stale(rv.Interval, rv.Updated, now)
+	return rv
+}
+
+// update records a new value for the PDO, using lookup to find the sensor
+// the first time the PDO is seen. A copy of the value is returned along
+// with whether it differs from the previous value.
+func (st *PDOStore) update(pdo int, data []byte, ts time.Time, lookup func() PDOSensor) (PDOValue, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	pv, ck := st.values[pdo]
+	if !ck {
+		pv = &PDOValue{ID: pdo, Sensor: lookup()}
+		st.values[pdo] = pv
+	}
+	changed := !ck || !bytes.Equal(pv.Value, data)
+	pv.Value = append(pv.Value[:0], data...)
+	pv.Updated = ts
+	return st.copyAt(pv, ts), changed
+}
+
+func (pv *PDOValue) copy() PDOValue {
+	rv := *pv
+	rv.Value = append([]byte(nil), pv.Value...)
+	return rv
+}
+
+// Get returns the value of a PDO.
+func (st *PDOStore) Get(pdo int) (PDOValue, bool) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	pv, ck := st.values[pdo]
+	if !ck {
+		return PDOValue{}, false
+	}
+	return st.copyAt(pv, time.Now()), true
+}
+
+// GetBySlug returns the value of the PDO for the sensor with the slug
+// supplied.
+func (st *PDOStore) GetBySlug(slug string) (PDOValue, bool) {
+	slug = strings.ToLower(slug)
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	for _, pv := range st.values {
+		if pv.Sensor.slug == slug {
+			return st.copyAt(pv, time.Now()), true
+		}
+	}
+	return PDOValue{}, false
+}
+
+// Snapshot returns the values of all PDOs received, ordered by PDO.
+func (st *PDOStore) Snapshot() []PDOValue {
+	now := time.Now()
+	st.mu.RLock()
+	rv := make([]PDOValue, 0, len(st.values))
+	for _, pv := range st.values {
+		rv = append(rv, st.copyAt(pv, now))
+	}
+	st.mu.RUnlock()
+	sort.Slice(rv, func(i, j int) bool { return rv[i].ID < rv[j].ID })
+	return rv
+}
@@ -0,0 +1,144 @@
+package zcan
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.einride.tech/can"
+)
+
+// watchPDORequests returns a channel receiving the PDO requests sent on
+// the bus.
+func watchPDORequests(t *testing.T, bus *VirtualBus) <-chan can.Frame {
+	t.Helper()
+	port := bus.Attach(false)
+	if err := port.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { port.Close() })
+	requests := make(chan can.Frame, 64)
+	go func() {
+		for {
+			frame, err := port.Receive()
+			if err != nil {
+				return
+			}
+			if frame.IsRemote && frame.ID>>24 == 0 {
+				requests <- frame.Frame
+			}
+		}
+	}()
+	return requests
+}
+
+// expectPDORequest waits for the request for a PDO from node 1 with the
+// interval given, skipping any others.
+func expectPDORequest(t *testing.T, requests <-chan can.Frame, pdo uint16, interval byte) {
+	t.Helper()
+	expected := pdoRequest{1, pdo}.frame(interval)
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case frame := <-requests:
+			if frame == expected {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("PDO %d wasn't requested with interval %d", pdo, interval)
+		}
+	}
+}
+
+// expectNoPDORequest checks that nothing is requested for a while.
+func expectNoPDORequest(t *testing.T, requests <-chan can.Frame) {
+	t.Helper()
+	select {
+	case frame := <-requests:
+		t.Errorf("unexpected PDO request %v", frame)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestPDOStoreStale(t *testing.T) {
+	st := newPDOStore()
+	now := time.Now()
+	for _, tc := range []struct {
+		interval time.Duration
+		age      time.Duration
+		expected bool
+	}{
+		{0, time.Hour, false},
+		{time.Second, 0, false},
+		{time.Second, 3 * time.Second, false},
+		{time.Second, 3*time.Second + time.Millisecond, true},
+		{10 * time.Second, 20 * time.Second, false},
+		{10 * time.Second, time.Minute, true},
+	} {
+		if got := st.stale(tc.interval, now.Add(-tc.age), now); got != tc.expected {
+			t.Errorf("interval %s, age %s: stale is %t, expected %t", tc.interval, tc.age, got, tc.expected)
+		}
+	}
+
+	st.setInterval(120, time.Second)
+	st.update(120, []byte{0xFA, 0x00}, now.Add(-5*time.Second), func() PDOSensor { return sensorData[120] })
+	if pv, _ := st.Get(120); !pv.Stale || pv.Interval != time.Second {
+		t.Errorf("value is %+v, expected to be stale with an interval of 1s", pv)
+	}
+	st.update(120, []byte{0xFA, 0x00}, now, nil)
+	if pv, _ := st.Get(120); pv.Stale {
+		t.Error("value still stale after an update")
+	}
+	st.setInterval(120, 0)
+	st.update(120, []byte{0xFA, 0x00}, now.Add(-time.Hour), nil)
+	if pv, _ := st.Get(120); pv.Stale {
+		t.Error("value sent on change marked as stale")
+	}
+}
+
+func TestRequestStale(t *testing.T) {
+	bus, dev := startVirtual(t)
+	requests := watchPDORequests(t, bus)
+
+	start := time.Now()
+	dev.RequestPDO(1, 120, 1)
+	expectPDORequest(t, requests, 120, 1)
+	dev.RequestPDO(1, 121, pdoOnChange)
+	expectPDORequest(t, requests, 121, pdoOnChange)
+
+	dev.requestStale(start.Add(2 * time.Second))
+	expectNoPDORequest(t, requests)
+
+	// never arrived, so requested again once per stale period
+	dev.requestStale(start.Add(4 * time.Second))
+	expectPDORequest(t, requests, 120, 1)
+	dev.requestStale(start.Add(5 * time.Second))
+	expectNoPDORequest(t, requests)
+
+	// a fresh value is left alone
+	dev.store.update(120, []byte{0xFA, 0x00}, time.Now(), func() PDOSensor { return sensorData[120] })
+	dev.requestStale(start.Add(8 * time.Second))
+	expectNoPDORequest(t, requests)
+
+	// cancelled requests aren't sent again
+	dev.RequestPDO(1, 120, 0)
+	expectPDORequest(t, requests, 120, 0)
+	dev.requestStale(start.Add(time.Minute))
+	expectNoPDORequest(t, requests)
+}
+
+func TestStaleRerequest(t *testing.T) {
+	bus := NewVirtualBus()
+	dev := NewZehnderDevice(55, WithTransport(bus.Attach(false)), WithLogger(testLogger),
+		WithHeartbeatInterval(100*time.Millisecond), WithStaleAfter(1), WithStaleRerequest())
+	if err := dev.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(dev.Stop)
+	requests := watchPDORequests(t, bus)
+
+	dev.RequestPDO(1, 120, 1)
+	expectPDORequest(t, requests, 120, 1)
+	// nothing answers, so the request is repeated after a second
+	expectPDORequest(t, requests, 120, 1)
+}
@@ -0,0 +1,112 @@
+package zcan
+
+import (
+	"slices"
+	"strings"
+	"sync"
+)
+
+const subscriptionQueueSize = 64
+
+// PDOFilter selects the PDO updates delivered to a Subscription. Each
+// non-empty list must contain the PDO, sensor slug or node ID of an update
+// for it to be delivered, so the zero value matches every update.
+type PDOFilter struct {
+	PDOs  []int
+	Slugs []string
+	Nodes []byte
+	// ChangesOnly restricts updates to those where the value differs from
+	// the previous one received.
+	ChangesOnly bool
+}
+
+func (f PDOFilter) matches(update PDOUpdate) bool {
+	if f.ChangesOnly && !update.Changed {
+		return false
+	}
+	if len(f.PDOs) > 0 && !slices.Contains(f.PDOs, update.Value.ID) {
+		return false
+	}
+	if len(f.Nodes) > 0 && !slices.Contains(f.Nodes, update.Node) {
+		return false
+	}
+	if len(f.Slugs) > 0 {
+		found := false
+		for _, slug := range f.Slugs {
+			if strings.ToLower(slug) == update.Value.Sensor.slug {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// PDOUpdate is delivered to subscribers each time a PDO value is received.
+type PDOUpdate struct {
+	Node    byte
+	Value   PDOValue
+	Changed bool
+}
+
+// Subscription delivers the PDO updates matching its filter on C. Updates
+// are dropped rather than holding up the device if C isn't read quickly
+// enough. C is closed by Close.
+type Subscription struct {
+	C <-chan PDOUpdate
+
+	dev     *ZehnderDevice
+	c       chan PDOUpdate
+	filter  PDOFilter
+	mu      sync.Mutex
+	dropped uint64
+}
+
+// Subscribe returns a Subscription for the PDO updates matching filter.
+func (dev *ZehnderDevice) Subscribe(filter PDOFilter) *Subscription {
+	c := make(chan PDOUpdate, subscriptionQueueSize)
+	sub := &Subscription{C: c, dev: dev, c: c, filter: filter}
+	dev.subMu.Lock()
+	dev.subscriptions[sub] = struct{}{}
+	dev.subMu.Unlock()
+	return sub
+}
+
+// Close stops delivery of updates and closes C.
+func (sub *Subscription) Close() {
+	sub.dev.subMu.Lock()
+	defer sub.dev.subMu.Unlock()
+	if _, ck := sub.dev.subscriptions[sub]; !ck {
+		return
+	}
+	delete(sub.dev.subscriptions, sub)
+	close(sub.c)
+}
+
+// Dropped returns the number of updates that couldn't be delivered because
+// C was full.
+func (sub *Subscription) Dropped() uint64 {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	return sub.dropped
+}
+
+func (dev *ZehnderDevice) publishPDO(update PDOUpdate) {
+	dev.subMu.Lock()
+	defer dev.subMu.Unlock()
+	for sub := range dev.subscriptions {
+		if !sub.filter.matches(update) {
+			continue
+		}
+		select {
+		case sub.c <- update:
+		default:
+			sub.mu.Lock()
+			sub.dropped++
+			sub.mu.Unlock()
+		}
+	}
+}
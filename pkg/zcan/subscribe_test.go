@@ -0,0 +1,44 @@
+package zcan
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestPDOSubscription(t *testing.T) {
+	bus, dev := startVirtual(t)
+	sim := NewSimulator(bus.Attach(false))
+	if err := sim.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer sim.Stop()
+
+	sub := dev.Subscribe(PDOFilter{PDOs: []int{120}, ChangesOnly: true})
+	defer sub.Close()
+	next := func() PDOUpdate {
+		t.Helper()
+		select {
+		case update := <-sub.C:
+			return update
+		case <-time.After(5 * time.Second):
+			t.Fatal("no PDO update received")
+		}
+		return PDOUpdate{}
+	}
+
+	dev.RequestPDO(1, 120, 0xFF)
+	update := next()
+	if update.Node != 1 || update.Value.ID != 120 || !bytes.Equal(update.Value.Value, []byte{0xFA, 0x00}) {
+		t.Fatalf("unexpected update %+v", update)
+	}
+
+	sim.SetPDO(120, []byte{0x2C, 0x01})
+	update = next()
+	if !bytes.Equal(update.Value.Value, []byte{0x2C, 0x01}) {
+		t.Errorf("value is % X, expected 2C 01", update.Value.Value)
+	}
+	if pv, ck := dev.Store().Get(120); !ck || !bytes.Equal(pv.Value, []byte{0x2C, 0x01}) {
+		t.Errorf("store holds %+v", pv)
+	}
+}
@@ -0,0 +1,86 @@
+package zcan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.einride.tech/can"
+)
+
+// Flags and masks used in the Linux can_id encoding, which is shared by
+// several of the network protocols.
+const (
+	canEFFFlag = 0x80000000
+	canRTRFlag = 0x40000000
+	canERRFlag = 0x20000000
+	canSFFMask = 0x000007FF
+	canEFFMask = 0x1FFFFFFF
+)
+
+// ErrBusOff is returned by Receive when the CAN controller has gone bus-off.
+var ErrBusOff = errors.New("CAN controller is bus-off")
+
+// TimedFrame is a CAN frame along with the time it was received from, or
+// transmitted to, the bus.
+type TimedFrame struct {
+	can.Frame
+	Timestamp time.Time
+}
+
+// Transport is the link between a ZehnderDevice and a CAN bus. The device
+// opens the transport once when started, reads frames from it in one
+// goroutine and writes frames to it from another, closing it on Stop.
+// Receive must return an error once the transport has been closed. Frames
+// are timestamped by the kernel where possible, otherwise as they are read.
+type Transport interface {
+	Open() error
+	Receive() (TimedFrame, error)
+	Transmit(ctx context.Context, frame can.Frame) error
+	Close() error
+}
+
+// NewTransport returns a Transport for an interface specification. A plain
+// name such as can0 uses socketcan, while a prefixed name selects another
+// backend:
+//
+//	slcan:/dev/ttyACM0              slcan adapter on a serial port
+//	cannelloni:10.0.0.2:20000       cannelloni peer, receiving on local port 20000
+//	socketcand:10.0.0.2:29536/can0  bus can0 on a socketcand server
+func NewTransport(spec string) (Transport, error) {
+	kind, addr, found := strings.Cut(spec, ":")
+	if !found {
+		return NewSocketCANTransport(spec)
+	}
+	switch kind {
+	case "socketcan":
+		return NewSocketCANTransport(addr)
+	case "slcan":
+		return NewSLCANTransport(addr)
+	case "cannelloni":
+		return NewCannelloniTransport(addr, "")
+	case "socketcand":
+		host, bus, _ := strings.Cut(addr, "/")
+		return NewSocketcandTransport(host, bus)
+	}
+	return nil, fmt.Errorf("unknown transport type '%s'", kind)
+}
+
+// CANFilter matches received frames whose ID, masked by Mask, equals ID
+// masked by Mask. As with SocketCAN, the extended and RTR flags may be
+// included in both ID and Mask.
+type CANFilter struct {
+	ID   uint32
+	Mask uint32
+}
+
+// FilteringTransport is implemented by transports able to drop unwanted
+// frames before they reach the device, e.g. in the kernel. An empty list
+// of filters allows all frames through. Filters may be changed while the
+// transport is open and are kept when it is reopened.
+type FilteringTransport interface {
+	Transport
+	SetFilters(filters []CANFilter) error
+}
@@ -0,0 +1,188 @@
+package zcan
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// zehnderEpoch is the start of the times sent as CN_TIME.
+var zehnderEpoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// ValueKind identifies the type held by a Value.
+type ValueKind int
+
+const (
+	ValueInvalid ValueKind = iota
+	ValueInt
+	ValueFloat
+	ValueBool
+	ValueString
+	ValueEnum
+	ValueDuration
+	ValueTime
+)
+
+func (kind ValueKind) String() string {
+	switch kind {
+	case ValueInt:
+		return "int"
+	case ValueFloat:
+		return "float"
+	case ValueBool:
+		return "bool"
+	case ValueString:
+		return "string"
+	case ValueEnum:
+		return "enum"
+	case ValueDuration:
+		return "duration"
+	case ValueTime:
+		return "time"
+	}
+	return "invalid"
+}
+
+// Value is a decoded PDO value. Enums hold the raw value in Int and its
+// label in Str. Values that couldn't be decoded are ValueInvalid.
+type Value struct {
+	Kind     ValueKind
+	Int      int64
+	Float    float64
+	Bool     bool
+	Str      string
+	Duration time.Duration
+	Time     time.Time
+
+	decimals int
+}
+
+// decodeInt returns the little endian integer at the start of data, with
+// the signed types decoded as two's complement.
+func decodeInt(typ ZehnderType, data []byte) (int64, error) {
+	if len(data) < typ.size() {
+		return 0, fmt.Errorf("%d bytes is too short for %s", len(data), typ)
+	}
+	switch typ {
+	case CN_BOOL, CN_UINT8:
+		return int64(data[0]), nil
+	case CN_UINT16:
+		return int64(binary.LittleEndian.Uint16(data)), nil
+	case CN_UINT32, CN_TIME, CN_VERSION:
+		return int64(binary.LittleEndian.Uint32(data)), nil
+	case CN_INT8:
+		return int64(int8(data[0])), nil
+	case CN_INT16:
+		return int64(int16(binary.LittleEndian.Uint16(data))), nil
+	case CN_INT64:
+		return int64(binary.LittleEndian.Uint64(data)), nil
+	}
+	return 0, fmt.Errorf("%s is not an integer type", typ)
+}
+
+// scaled applies the sensor's scale and offset to a raw value, rounding to
+// the precision given by the scale and offset.
+func (sensor PDOSensor) scaled(raw int64) float64 {
+	scale := sensor.Scale
+	if scale == 0 {
+		scale = 1
+	}
+	p := math.Pow10(sensor.DecimalPlaces)
+	return math.Round((float64(raw)*scale+sensor.Offset)*p) / p
+}
+
+// Decode returns the value of the data sent for the sensor. Numbers listed
+// in the sensor's Enum are returned as enums, those in seconds as durations
+// and scaled numbers as floats.
+func (sensor PDOSensor) Decode(data []byte) Value {
+	if sensor.DataType == CN_STRING {
+		if n := bytes.IndexByte(data, 0); n != -1 {
+			data = data[:n]
+		}
+		return Value{Kind: ValueString, Str: string(data)}
+	}
+	raw, err := decodeInt(sensor.DataType, data)
+	if err != nil {
+		return Value{}
+	}
+	switch sensor.DataType {
+	case CN_BOOL:
+		return Value{Kind: ValueBool, Int: raw, Bool: raw == 1}
+	case CN_VERSION:
+		vers := ZehnderVersionDecode(uint32(raw))
+		return Value{Kind: ValueString, Int: raw, Str: fmt.Sprintf("%d.%d", vers[0], vers[1])}
+	case CN_TIME:
+		return Value{Kind: ValueTime, Int: raw, Time: zehnderEpoch.Add(time.Duration(raw) * time.Second)}
+	}
+	if label, ck := sensor.Enum[raw]; ck {
+		return Value{Kind: ValueEnum, Int: raw, Str: label}
+	}
+	if sensor.Units == UNIT_SECONDS {
+		return Value{Kind: ValueDuration, Int: raw, Duration: time.Duration(sensor.scaled(raw) * float64(time.Second))}
+	}
+	if (sensor.Scale == 0 || sensor.Scale == 1) && sensor.Offset == 0 {
+		return Value{Kind: ValueInt, Int: raw}
+	}
+	return Value{Kind: ValueFloat, Int: raw, Float: sensor.scaled(raw), decimals: sensor.DecimalPlaces}
+}
+
+// Interface returns the value as a Go value: int64, float64, bool, string
+// (for strings and enum labels), time.Time or, for durations, the number
+// of seconds as a float64. Invalid values are nil.
+func (v Value) Interface() interface{} {
+	switch v.Kind {
+	case ValueInt:
+		return v.Int
+	case ValueFloat:
+		return v.Float
+	case ValueBool:
+		return v.Bool
+	case ValueString, ValueEnum:
+		return v.Str
+	case ValueDuration:
+		return v.Duration.Seconds()
+	case ValueTime:
+		return v.Time
+	}
+	return nil
+}
+
+// Number returns the value as a number, if it has one. Enums give their
+// raw value, durations the number of seconds and booleans 0 or 1.
+func (v Value) Number() (float64, bool) {
+	switch v.Kind {
+	case ValueInt, ValueEnum, ValueBool:
+		return float64(v.Int), true
+	case ValueFloat:
+		return v.Float, true
+	case ValueDuration:
+		return v.Duration.Seconds(), true
+	}
+	return 0, false
+}
+
+func (v Value) String() string {
+	switch v.Kind {
+	case ValueInt:
+		return strconv.FormatInt(v.Int, 10)
+	case ValueFloat:
+		return strconv.FormatFloat(v.Float, 'f', v.decimals, 64)
+	case ValueBool:
+		return strconv.FormatBool(v.Bool)
+	case ValueString, ValueEnum:
+		return v.Str
+	case ValueDuration:
+		return v.Duration.String()
+	case ValueTime:
+		return v.Time.Format(time.RFC3339)
+	}
+	return "invalid"
+}
+
+func (v Value) MarshalJSON() ([]byte, error) {
+	return json.Marshal(v.Interface())
+}
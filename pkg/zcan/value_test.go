@@ -0,0 +1,84 @@
+package zcan
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDecodeInt(t *testing.T) {
+	for _, tc := range []struct {
+		typ      ZehnderType
+		data     []byte
+		expected int64
+		invalid  bool
+	}{
+		{CN_BOOL, []byte{0x01}, 1, false},
+		{CN_UINT8, []byte{0xFF}, 255, false},
+		{CN_INT8, []byte{0xFF}, -1, false},
+		{CN_INT8, []byte{0x80}, -128, false},
+		{CN_UINT16, []byte{0x9F, 0x03}, 927, false},
+		{CN_INT16, []byte{0xE9, 0xFF}, -23, false},
+		{CN_INT16, []byte{0x00, 0x80}, -32768, false},
+		{CN_UINT32, []byte{0x84, 0x03, 0x00, 0x00}, 900, false},
+		{CN_UINT32, []byte{0xFF, 0xFF, 0xFF, 0xFF}, 4294967295, false},
+		{CN_INT64, []byte{0xFE, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}, -2, false},
+		{CN_UINT16, []byte{0x9F, 0x03, 0x55}, 927, false},
+		{CN_INT16, []byte{0xE9}, 0, true},
+		{CN_UINT32, []byte{0x84, 0x03}, 0, true},
+		{CN_UINT8, nil, 0, true},
+		{CN_STRING, []byte("abc"), 0, true},
+	} {
+		got, err := decodeInt(tc.typ, tc.data)
+		if tc.invalid {
+			if err == nil {
+				t.Errorf("%s % X: decoded as %d, expected an error", tc.typ, tc.data, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s % X: %v", tc.typ, tc.data, err)
+		} else if got != tc.expected {
+			t.Errorf("%s % X: got %d, expected %d", tc.typ, tc.data, got, tc.expected)
+		}
+	}
+}
+
+func TestSensorDecode(t *testing.T) {
+	offset := PDOSensor{DataType: CN_UINT8, Scale: 0.5, Offset: -40, DecimalPlaces: 1}
+	halfOffset := mustParseCatalog([]byte(`{"version": 1, "sensors": [{"pdo": 1, "name": "Test", "type": "uint8", "offset": 0.5}]}`))[1]
+	for _, tc := range []struct {
+		name     string
+		sensor   PDOSensor
+		data     []byte
+		kind     ValueKind
+		expected string
+	}{
+		{"supply fan flow", sensorData[120], []byte{0xFA, 0x00}, ValueInt, "250"},
+		{"extract air temperature", sensorData[274], []byte{0xD2, 0x00}, ValueFloat, "21.0"},
+		{"outdoor air temperature", sensorData[276], []byte{0xE9, 0xFF}, ValueFloat, "-2.3"},
+		{"avoided heating", sensorData[213], []byte{0x9F, 0x03}, ValueFloat, "9.27"},
+		{"analog input", sensorData[369], []byte{0x64}, ValueFloat, "10.0"},
+		{"scale and offset", offset, []byte{0x6B}, ValueFloat, "13.5"},
+		{"fractional offset", halfOffset, []byte{0x14}, ValueFloat, "20.5"},
+		{"operating mode auto", sensorData[49], []byte{0xFF}, ValueEnum, "Auto"},
+		{"operating mode unlisted", sensorData[49], []byte{0x03}, ValueInt, "3"},
+		{"fan speed", sensorData[65], []byte{0x02}, ValueEnum, "Medium"},
+		{"heating season", sensorData[210], []byte{0x01}, ValueBool, "true"},
+		{"cooling season", sensorData[211], []byte{0x00}, ValueBool, "false"},
+		{"boost remaining", sensorData[81], []byte{0x84, 0x03, 0x00, 0x00}, ValueDuration, "15m0s"},
+		{"version", PDOSensor{DataType: CN_VERSION}, []byte{0x00, 0x00, 0x10, 0xC0}, ValueString, "3.1"},
+		{"time", PDOSensor{DataType: CN_TIME}, []byte{0x80, 0x51, 0x01, 0x00}, ValueTime, "2000-01-02T00:00:00Z"},
+		{"short temperature", sensorData[276], []byte{0xE9}, ValueInvalid, "invalid"},
+		{"short duration", sensorData[81], []byte{0x84, 0x03}, ValueInvalid, "invalid"},
+		{"empty", sensorData[120], nil, ValueInvalid, "invalid"},
+	} {
+		got := tc.sensor.Decode(tc.data)
+		if got.Kind != tc.kind || got.String() != tc.expected {
+			t.Errorf("%s: got %s %q, expected %s %q", tc.name, got.Kind, got, tc.kind, tc.expected)
+		}
+	}
+
+	if got := sensorData[81].Decode([]byte{0x84, 0x03, 0x00, 0x00}).Duration; got != 15*time.Minute {
+		t.Errorf("boost remaining is %s, expected 15m", got)
+	}
+}
@@ -0,0 +1,153 @@
+package zcan
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.einride.tech/can"
+)
+
+// ErrPortClosed is returned by a VirtualPort that is used while closed.
+var ErrPortClosed = errors.New("virtual bus port is closed")
+
+const virtualPortQueueSize = 256
+
+// VirtualBus is an in-process CAN bus. Every frame transmitted by one of
+// the attached ports is delivered to all other open ports, so several
+// ZehnderDevice instances and test harnesses can talk to each other without
+// any CAN hardware. A port whose queue is full misses the frame rather than
+// holding up the sender, in the same way a slow reader on a real bus would.
+type VirtualBus struct {
+	mu    sync.Mutex
+	ports []*VirtualPort
+}
+
+func NewVirtualBus() *VirtualBus {
+	return &VirtualBus{}
+}
+
+// Attach returns a new port for the bus. The port joins the bus when it is
+// opened and leaves it when closed. When loopback is true frames
+// transmitted via the port are also delivered back to it.
+func (bus *VirtualBus) Attach(loopback bool) *VirtualPort {
+	return &VirtualPort{bus: bus, loopback: loopback}
+}
+
+func (bus *VirtualBus) add(port *VirtualPort) {
+	bus.mu.Lock()
+	bus.ports = append(bus.ports, port)
+	bus.mu.Unlock()
+}
+
+func (bus *VirtualBus) remove(port *VirtualPort) {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	for i, p := range bus.ports {
+		if p == port {
+			bus.ports = append(bus.ports[:i], bus.ports[i+1:]...)
+			return
+		}
+	}
+}
+
+func (bus *VirtualBus) deliver(from *VirtualPort, frame can.Frame) {
+	bus.mu.Lock()
+	ports := make([]*VirtualPort, len(bus.ports))
+	copy(ports, bus.ports)
+	bus.mu.Unlock()
+
+	tf := TimedFrame{Frame: frame, Timestamp: time.Now()}
+	for _, port := range ports {
+		if port == from && !port.loopback {
+			continue
+		}
+		port.deliver(tf)
+	}
+}
+
+// VirtualPort is a Transport attached to a VirtualBus.
+type VirtualPort struct {
+	bus      *VirtualBus
+	loopback bool
+	dropped  atomic.Uint64
+
+	mu   sync.Mutex
+	rxQ  chan TimedFrame
+	done chan struct{}
+}
+
+func (port *VirtualPort) state() (chan TimedFrame, chan struct{}) {
+	port.mu.Lock()
+	defer port.mu.Unlock()
+	return port.rxQ, port.done
+}
+
+func (port *VirtualPort) Open() error {
+	port.mu.Lock()
+	defer port.mu.Unlock()
+	if port.done != nil {
+		return nil
+	}
+	port.rxQ = make(chan TimedFrame, virtualPortQueueSize)
+	port.done = make(chan struct{})
+	port.bus.add(port)
+	return nil
+}
+
+// Dropped returns the number of frames the port missed because its queue
+// was full.
+func (port *VirtualPort) Dropped() uint64 {
+	return port.dropped.Load()
+}
+
+func (port *VirtualPort) Receive() (TimedFrame, error) {
+	rxQ, done := port.state()
+	if done == nil {
+		return TimedFrame{}, ErrPortClosed
+	}
+	select {
+	case frame := <-rxQ:
+		return frame, nil
+	case <-done:
+		return TimedFrame{}, ErrPortClosed
+	}
+}
+
+func (port *VirtualPort) Transmit(ctx context.Context, frame can.Frame) error {
+	if _, done := port.state(); done == nil {
+		return ErrPortClosed
+	}
+	port.bus.deliver(port, frame)
+	return nil
+}
+
+func (port *VirtualPort) deliver(frame TimedFrame) {
+	port.mu.Lock()
+	defer port.mu.Unlock()
+	if port.done == nil {
+		return
+	}
+	select {
+	case port.rxQ <- frame:
+	default:
+		port.dropped.Add(1)
+	}
+}
+
+func (port *VirtualPort) Close() error {
+	port.mu.Lock()
+	open := port.done != nil
+	if open {
+		close(port.done)
+		port.done = nil
+		port.rxQ = nil
+	}
+	port.mu.Unlock()
+	if open {
+		port.bus.remove(port)
+	}
+	return nil
+}
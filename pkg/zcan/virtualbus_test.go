@@ -0,0 +1,156 @@
+package zcan
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.einride.tech/can"
+)
+
+// testResponder answers RMI requests sent to node 1 on its port with the
+// supplied reply data.
+func testResponder(t *testing.T, port *VirtualPort, reply []byte) {
+	t.Helper()
+	if err := port.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { port.Close() })
+	go func() {
+		for {
+			frame, err := port.Receive()
+			if err != nil {
+				return
+			}
+			if frame.ID>>24 != 0x1F {
+				continue
+			}
+			req, err := rmiFromFrame(frame.Frame)
+			if err != nil || !req.IsRequest || req.DestId != 1 {
+				continue
+			}
+			rmi := ZehnderRMI{SourceId: 1, DestId: req.SourceId, Sequence: req.Sequence}
+			rsp := can.Frame{ID: rmi.MakeCANId(), IsExtended: true}
+			rsp.Length = uint8(copy(rsp.Data[:], reply))
+			port.Transmit(context.Background(), rsp)
+		}
+	}()
+}
+
+// startVirtual starts a device on a new virtual bus, returning the bus.
+func startVirtual(t *testing.T) (*VirtualBus, *ZehnderDevice) {
+	t.Helper()
+	bus := NewVirtualBus()
+	dev := NewZehnderDevice(55)
+	dev.SetTransport(bus.Attach(false))
+	if err := dev.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(dev.Stop)
+	return bus, dev
+}
+
+func TestVirtualBusFullPort(t *testing.T) {
+	bus := NewVirtualBus()
+	sender, stuck, reader := bus.Attach(false), bus.Attach(false), bus.Attach(false)
+	for _, port := range []*VirtualPort{sender, stuck, reader} {
+		if err := port.Open(); err != nil {
+			t.Fatal(err)
+		}
+		defer port.Close()
+	}
+
+	const frames = virtualPortQueueSize + 44
+	transmit := func(from, to int) {
+		for i := from; i < to; i++ {
+			if err := sender.Transmit(context.Background(), can.Frame{ID: uint32(i)}); err != nil {
+				t.Fatalf("transmit %d: %v", i, err)
+			}
+		}
+	}
+	receive := func(n int) {
+		for i := 0; i < n; i++ {
+			if _, err := reader.Receive(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// fill every queue, then keep sending once the reader has caught up
+	transmit(0, virtualPortQueueSize)
+	receive(virtualPortQueueSize)
+	transmit(virtualPortQueueSize, frames)
+	receive(frames - virtualPortQueueSize)
+
+	if got := stuck.Dropped(); got != frames-virtualPortQueueSize {
+		t.Errorf("stuck port dropped %d frames, expected %d", got, frames-virtualPortQueueSize)
+	}
+	if got := reader.Dropped(); got != 0 {
+		t.Errorf("reader dropped %d frames", got)
+	}
+}
+
+func TestVirtualBusDetach(t *testing.T) {
+	bus := NewVirtualBus()
+	port := bus.Attach(false)
+	for i := 0; i < 3; i++ {
+		if err := port.Open(); err != nil {
+			t.Fatal(err)
+		}
+		if len(bus.ports) != 1 {
+			t.Fatalf("bus has %d ports while open, expected 1", len(bus.ports))
+		}
+		port.Close()
+		if len(bus.ports) != 0 {
+			t.Fatalf("bus has %d ports after close, expected 0", len(bus.ports))
+		}
+	}
+}
+
+func TestRMIRoundTrip(t *testing.T) {
+	bus, dev := startVirtual(t)
+	testResponder(t, bus.Attach(false), []byte("SIT0001\x00"))
+
+	replies := make(chan *ZehnderRMI, 1)
+	NewZehnderDestination(1, 1, 1).GetOne(dev, 4, ZehnderRMITypeActualValue, func(rmi *ZehnderRMI) {
+		replies <- rmi
+	})
+	var rmi *ZehnderRMI
+	select {
+	case rmi = <-replies:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reply to GetOne")
+	}
+	if rmi.IsError {
+		t.Fatalf("GetOne failed with error %v", rmi.Data[:rmi.DataLength])
+	}
+	if val, err := rmi.GetData(CN_STRING); err != nil || val != "SIT0001" {
+		t.Errorf("got %v (%v), expected SIT0001", val, err)
+	}
+}
+
+func TestPDORequest(t *testing.T) {
+	bus, dev := startVirtual(t)
+	port := bus.Attach(false)
+	if err := port.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer port.Close()
+
+	dev.RequestPDO(1, 120, 0xFF)
+	expected := uint32(120)<<14 | 0x41
+	for {
+		frame, err := port.Receive()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if frame.ID != expected {
+			// heartbeats
+			continue
+		}
+		if !frame.IsRemote || frame.Length != 1 || frame.Data[0] != 0xFF {
+			t.Fatalf("unexpected PDO request %v", frame)
+		}
+		return
+	}
+}
@@ -1,12 +1,17 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/zathras777/zcan/pkg/zcan"
 )
@@ -29,35 +34,86 @@ func dumpStoredRMI() {
 	}
 }
 
-func requestPDO(dev *zcan.ZehnderDevice) {
-	type pdoToRequest struct {
-		productId byte
-		pdoId     uint16
-		frequency byte
-	}
-	var requests = []pdoToRequest{
-		{1, 49, 0xff},
-		{1, 65, 0xff},
-		{1, 81, 1},
-		{1, 117, 5},
-		{1, 118, 5},
-		{1, 119, 5},
-		{1, 120, 5},
-		{1, 121, 5},
-		{1, 122, 5},
-		{1, 192, 0xff},
-		{1, 209, 0xff},
-		{1, 227, 0x10},
-		{1, 274, 2},
-		{1, 275, 2},
-		{1, 276, 2},
-		{1, 278, 2},
-	}
-	for _, req := range requests {
-		dev.RequestPDO(req.productId, req.pdoId, req.frequency)
-	}
-	dev.RequestPDOBySlug(1, "exhaust_humidity", 2)
+type linkOptions struct {
+	manage    bool
+	bitrate   uint
+	restartMs uint
+}
+
+// openTransport returns the transport for the interface. Only socketcan
+// interfaces can have their link managed.
+func openTransport(intName string, link linkOptions) (zcan.Transport, error) {
+	if !link.manage {
+		return zcan.NewTransport(intName)
+	}
+	name := strings.TrimPrefix(intName, "socketcan:")
+	if strings.Contains(name, ":") {
+		return nil, fmt.Errorf("-manage-link can only be used with socketcan interfaces")
+	}
+	restartMs := uint32(link.restartMs)
+	return zcan.NewSocketCANTransportWithConfig(zcan.SocketCANConfig{
+		Interface:  name,
+		ManageLink: true,
+		Bitrate:    uint32(link.bitrate),
+		RestartMs:  &restartMs,
+	})
+}
+
+func addReceiveFilters(dev *zcan.ZehnderDevice, filters string) error {
+	if filters == "" {
+		return nil
+	}
+	for _, filter := range strings.Split(filters, ",") {
+		idStr, maskStr, found := strings.Cut(filter, ":")
+		if !found {
+			return fmt.Errorf("invalid filter '%s', expected id:mask", filter)
+		}
+		id, err := strconv.ParseUint(idStr, 16, 32)
+		if err != nil {
+			return fmt.Errorf("invalid filter id '%s': %w", idStr, err)
+		}
+		mask, err := strconv.ParseUint(maskStr, 16, 32)
+		if err != nil {
+			return fmt.Errorf("invalid filter mask '%s': %w", maskStr, err)
+		}
+		if err := dev.AddReceiveFilter(zcan.CANFilter{ID: uint32(id), Mask: uint32(mask)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
+// newLogger returns a logger writing text to w at the named level.
+func newLogger(w io.Writer, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, err
+	}
+	return slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{Level: lvl})), nil
+}
+
+func runSimulator(intName string, link linkOptions, logger *slog.Logger) {
+	if intName == "" {
+		fmt.Println("An interface name is required to run the simulator.")
+		return
+	}
+	t, err := openTransport(intName, link)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	sim := zcan.NewSimulator(t)
+	sim.Logger = logger
+	if err := sim.Start(); err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("Simulating ComfoAir Q unit on %s. CTRL+C to quit...\n", intName)
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	<-sigs
+	sim.Stop()
 }
 
 func main() {
@@ -69,29 +125,113 @@ func main() {
 		captureFn    string
 		host         string
 		port         int
+		simulate     bool
+		scandPort    int
+		scandBus     string
+		link         linkOptions
+		filters      string
+		logLevel     string
+		listenOnly   bool
+		heartbeat    time.Duration
+		catalogFn    string
+		staleAfter   int
+		rerequest    bool
+		profile      string
+		profilesFn   string
 	)
 
 	flag.IntVar(&nodeId, "nodeid", 55, "Node ID to use for client")
 	flag.StringVar(&dumpFilename, "dumpfile", "", "Dump file to process")
-	flag.StringVar(&intName, "interface", "", "CAN Network Interface name")
+	flag.StringVar(&intName, "interface", "", "CAN interface, e.g. can0 or slcan:/dev/ttyACM0")
 	flag.BoolVar(&captureAll, "capture", false, "Capture all CAN packets for debugging")
 	flag.StringVar(&captureFn, "capture-filename", "output", "Capture filename [default: output]")
 	flag.IntVar(&port, "port", 7004, "Port for HTTP server")
 	flag.StringVar(&host, "address", "127.0.0.1", "Address for HTTP server")
+	flag.BoolVar(&simulate, "simulate", false, "Simulate a ComfoAir Q unit on the interface")
+	flag.IntVar(&scandPort, "socketcand-port", 0, "Port for socketcand server [default: disabled]")
+	flag.StringVar(&scandBus, "socketcand-bus", "zcan", "Bus name offered by the socketcand server")
+	flag.BoolVar(&link.manage, "manage-link", false, "Configure the socketcan interface and bring it up (requires CAP_NET_ADMIN)")
+	flag.UintVar(&link.bitrate, "bitrate", zcan.DefaultBitrate, "Bitrate to configure when managing the link")
+	flag.UintVar(&link.restartMs, "restart-ms", 100, "Bus-off restart delay in ms to configure when managing the link, 0 to disable")
+	flag.StringVar(&filters, "receive-filter", "", "Additional frames to receive as comma separated id:mask pairs in hex")
+	flag.StringVar(&logLevel, "log-level", "info", "Logging level: debug, info, warn or error")
+	flag.BoolVar(&listenOnly, "listen-only", false, "Decode bus traffic without transmitting")
+	flag.StringVar(&catalogFn, "catalog", "", "Sensor catalog file extending the built in catalog")
+	flag.IntVar(&staleAfter, "stale-after", 3, "Number of missed intervals before a sensor value is stale")
+	flag.BoolVar(&rerequest, "rerequest-stale", false, "Request sensor values again when they go stale")
+	flag.StringVar(&profile, "profile", "full", "Profile of sensors to request: minimal, energy, full or one from -profiles")
+	flag.StringVar(&profilesFn, "profiles", "", "Profiles file extending the built in profiles")
+	flag.DurationVar(&heartbeat, "heartbeat", 2*time.Second, "Interval between heartbeats sent by the client")
 	flag.Parse()
 
+	logger, err := newLogger(os.Stderr, logLevel)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if simulate {
+		runSimulator(intName, link, logger)
+		return
+	}
+
 	if dumpFilename == "" && intName == "" {
 		fmt.Println("Nothing to do. Specify either a dump filename or interface name.")
 		return
 	}
 
-	dev = zcan.NewZehnderDevice(byte(nodeId & 0xff))
+	if dumpFilename == "" {
+		f, err := os.OpenFile("zcan.log", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+		if err != nil {
+			fmt.Printf("error opening log file: %v\n", err)
+			return
+		}
+		defer f.Close()
+
+		logger, _ = newLogger(f, logLevel)
+	}
+	slog.SetDefault(logger)
+
+	opts := []zcan.Option{zcan.WithLogger(logger), zcan.WithHeartbeatInterval(heartbeat), zcan.WithStaleAfter(staleAfter)}
+	if rerequest {
+		opts = append(opts, zcan.WithStaleRerequest())
+	}
+	if listenOnly {
+		opts = append(opts, zcan.WithListenOnly())
+	}
+	if profilesFn != "" {
+		profiles, err := zcan.LoadProfiles(profilesFn)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		opts = append(opts, zcan.WithProfiles(profiles))
+	}
+	if dumpFilename == "" {
+		opts = append(opts, zcan.WithProfile(profile))
+	}
+	if catalogFn != "" {
+		catalog, err := zcan.LoadSensorCatalog(catalogFn)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		opts = append(opts, zcan.WithCatalog(catalog))
+	}
 	if intName != "" {
-		if err := dev.Connect(intName); err != nil {
+		t, err := openTransport(intName, link)
+		if err != nil {
 			fmt.Println(err)
 			return
 		}
-		dev.StartHttpServer(host, port)
+		opts = append(opts, zcan.WithTransport(t))
+		if dumpFilename == "" {
+			opts = append(opts, zcan.WithHTTPServer(zcan.HTTPConfig{Host: host, Port: port}))
+		}
+	}
+	dev = zcan.NewZehnderDevice(byte(nodeId&0xff), opts...)
+	if err := addReceiveFilters(dev, filters); err != nil {
+		fmt.Println(err)
+		return
 	}
 	if captureAll {
 		if dumpFilename != "" {
@@ -101,38 +241,34 @@ func main() {
 		}
 	}
 
-	if dumpFilename == "" {
-		f, err := os.OpenFile("zcan.log", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
-		if err != nil {
-			log.Fatalf("error opening file: %v", err)
-		}
-		defer f.Close()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-		log.SetOutput(f)
+	if err := dev.Start(ctx); err != nil {
+		fmt.Println(err)
+		return
 	}
 
-	dev.Start()
-
 	if dumpFilename != "" {
 		dev.SetDefaultRMICallback(storeRMI)
 		fmt.Printf("Processing dumpfile: %s\n", dumpFilename)
-		dev.ProcessDumpFile(dumpFilename)
+		if err := dev.ProcessDumpFile(dumpFilename); err != nil {
+			fmt.Println(err)
+		}
 		dev.Stop()
 		dumpStoredRMI()
 	} else {
+		if scandPort != 0 {
+			if err := dev.StartSocketcandServer(host, scandPort, scandBus); err != nil {
+				fmt.Println(err)
+			}
+		}
 		fmt.Printf("\n\nProcessing CAN packets. CTRL+C to quit...\n\n")
-		requestPDO(dev)
-
-		sigs := make(chan os.Signal, 1)
-		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
-
-		go func() {
-			<-sigs
-			dev.Stop()
-		}()
 	}
 	fmt.Println("Waiting for everything to complete...")
-	dev.Wait()
+	if err := dev.Wait(); err != nil {
+		fmt.Println(err)
+	}
 
-	dev.DumpPDO()
+	dev.DumpPDO(os.Stdout)
 }